@@ -0,0 +1,121 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DODAS-TS/sts-wire/pkg/rclone"
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errBwLimitConflict  = errors.New("set either limit or upload and download")
+	errBwLimitTimetable = errors.New("the limit of a running instance cannot be a timetable, " +
+		"set it in the configuration and reload")
+)
+
+// BandwidthOptions of the rclone transfers, with the syntax of the rclone
+// --bwlimit flag.
+type BandwidthOptions struct {
+	Limit    string `mapstructure:"limit" yaml:"limit" flag:"bwlimit" desc:"bandwidth limit, e.g. 10M, upload:download 10M:off or a timetable \"08:00,10M 20:00,off\""`
+	Upload   string `mapstructure:"upload" yaml:"upload" desc:"upload bandwidth limit, e.g. 5M, used when limit is empty"`
+	Download string `mapstructure:"download" yaml:"download" desc:"download bandwidth limit, e.g. 20M, used when limit is empty"`
+	PerFile  string `mapstructure:"perFile" yaml:"perFile" desc:"bandwidth limit of each file, e.g. 1M"`
+}
+
+// Validate checks the limits.
+func (o BandwidthOptions) Validate() error {
+	var errs validator.Errors
+
+	if o.Limit != "" {
+		_, err := validator.BwLimit(o.Limit)
+		errs.Add("limit", err)
+
+		if o.Upload != "" || o.Download != "" {
+			errs.Add("limit", errBwLimitConflict)
+		}
+	}
+
+	for key, size := range map[string]string{"upload": o.Upload, "download": o.Download} {
+		if size != "" {
+			_, err := validator.Size(size)
+			errs.Add(key, err)
+		}
+	}
+
+	if o.PerFile != "" {
+		_, err := validator.BwLimit(o.PerFile)
+		errs.Add("perFile", err)
+	}
+
+	return errs.Err()
+}
+
+// Rate returns the limit for rclone: the limit or upload:download, empty if
+// there is no limit.
+func (o BandwidthOptions) Rate() string {
+	if o.Limit != "" || (o.Upload == "" && o.Download == "") {
+		return o.Limit
+	}
+
+	upload, download := o.Upload, o.Download
+	if upload == "" {
+		upload = "off"
+	}
+
+	if download == "" {
+		download = "off"
+	}
+
+	return upload + ":" + download
+}
+
+// Timetable reports if the limit is a timetable of [Day-]HH:MM,rate slots,
+// which rclone accepts only at the mount.
+func (o BandwidthOptions) Timetable() bool {
+	return isTimetable(o.Limit)
+}
+
+func isTimetable(limit string) bool {
+	return strings.Contains(limit, ",")
+}
+
+// setMountOptions sets the rclone options of the limits.
+func (o BandwidthOptions) setMountOptions(options *rclone.MountOptions) {
+	if rate := o.Rate(); rate != "" {
+		options.Set("--bwlimit", rate)
+	}
+
+	if o.PerFile != "" {
+		options.Set("--bwlimit-file", o.PerFile)
+	}
+}
+
+// setBwLimit changes the bandwidth limit of the running rclone, off to remove
+// it, and returns the new one. An empty rate returns the current limit. The
+// timetables are refused, rclone applies them only at the mount.
+func (l liveState) setBwLimit(rate string) (rclone.BwLimit, error) {
+	if rate != "" {
+		if _, err := validator.BwLimit(rate); err != nil {
+			return rclone.BwLimit{}, err
+		}
+
+		if isTimetable(rate) {
+			return rclone.BwLimit{}, errBwLimitTimetable
+		}
+	}
+
+	limit, err := l.rc.BwLimit(context.Background(), rate)
+	if err != nil {
+		return limit, fmt.Errorf("bandwidth limit %w", err)
+	}
+
+	if rate != "" {
+		log.Info().Str("rate", limit.Rate).Msg("bandwidth limit")
+	}
+
+	return limit, nil
+}
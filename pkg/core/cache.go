@@ -0,0 +1,182 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DODAS-TS/sts-wire/pkg/rclone"
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+	"github.com/gookit/color"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultWriteBack = 10 * time.Second
+
+var (
+	errNoValidCacheDuration  = errors.New("no valid duration, it cannot be negative")
+	errNoValidCacheTransfers = errors.New("no valid number of transfers, it cannot be negative")
+	// cacheModeDefaults are the values of the settings not set by the user.
+	cacheModeDefaults = map[string]CacheOptions{ // nolint:gochecknoglobals,exhaustivestruct
+		"off":     {},
+		"minimal": {WriteBack: defaultWriteBack},
+		"writes":  {MaxAge: time.Hour, WriteBack: defaultWriteBack},
+		"full":    {MaxSize: "10G", MaxAge: 24 * time.Hour, ReadAhead: "8M", WriteBack: defaultWriteBack},
+	}
+)
+
+// CacheOptions of the rclone VFS cache. The empty values select the defaults
+// of the local cache mode.
+type CacheOptions struct {
+	MaxSize            string        `mapstructure:"maxSize" yaml:"maxSize" flag:"cacheMaxSize" desc:"max total size of the cache, e.g. 20G or off, empty for the mode default (full: 10G)"`
+	MaxAge             time.Duration `mapstructure:"maxAge" yaml:"maxAge" desc:"max age of the files in the cache, 0 for the mode default (writes: 1h, full: 24h)"`
+	PollInterval       time.Duration `mapstructure:"pollInterval" yaml:"pollInterval" desc:"interval to poll the cache for stale files, 0 for 1m"`
+	ReadAhead          string        `mapstructure:"readAhead" yaml:"readAhead" desc:"extra read ahead of the full mode, e.g. 64M, empty for 8M"`
+	ReadChunkSize      string        `mapstructure:"readChunkSize" yaml:"readChunkSize" desc:"size of the chunks read from the bucket, e.g. 32M, empty for 128M"`
+	ReadChunkSizeLimit string        `mapstructure:"readChunkSizeLimit" yaml:"readChunkSizeLimit" desc:"max size of the chunks, doubled after each read, e.g. 1G or off"`
+	WriteBack          time.Duration `mapstructure:"writeBack" yaml:"writeBack" desc:"delay of the upload after the last write, 0 for 10s"`
+	Transfers          int           `mapstructure:"transfers" yaml:"transfers" desc:"parallel uploads of the cached files, 0 for 4"`
+	Keep               bool          `mapstructure:"keep" yaml:"keep" flag:"keepCache" desc:"keep the cache across restarts and remounts instead of deleting it"`
+}
+
+// Validate checks the sizes, the durations and the number of transfers.
+func (o CacheOptions) Validate() error {
+	var errs validator.Errors
+
+	for key, size := range map[string]string{
+		"maxSize":            o.MaxSize,
+		"readAhead":          o.ReadAhead,
+		"readChunkSize":      o.ReadChunkSize,
+		"readChunkSizeLimit": o.ReadChunkSizeLimit,
+	} {
+		if size != "" {
+			_, err := validator.Size(size)
+			errs.Add(key, err)
+		}
+	}
+
+	for key, duration := range map[string]time.Duration{
+		"maxAge":       o.MaxAge,
+		"pollInterval": o.PollInterval,
+		"writeBack":    o.WriteBack,
+	} {
+		if duration < 0 {
+			errs.Add(key, errNoValidCacheDuration)
+		}
+	}
+
+	if o.Transfers < 0 {
+		errs.Add("transfers", errNoValidCacheTransfers)
+	}
+
+	return errs.Err()
+}
+
+// withDefaults returns the options with the defaults of the cache mode in
+// place of the values not set.
+func (o CacheOptions) withDefaults(mode string) CacheOptions {
+	defaults := cacheModeDefaults[strings.ToLower(mode)]
+
+	if o.MaxSize == "" {
+		o.MaxSize = defaults.MaxSize
+	}
+
+	if o.MaxAge == 0 {
+		o.MaxAge = defaults.MaxAge
+	}
+
+	if o.ReadAhead == "" {
+		o.ReadAhead = defaults.ReadAhead
+	}
+
+	if o.WriteBack == 0 {
+		o.WriteBack = defaults.WriteBack
+	}
+
+	return o
+}
+
+// setMountOptions sets the rclone options of the cache. The cache settings
+// are used only when the cache is on, the chunk sizes in all the modes.
+func (o CacheOptions) setMountOptions(options *rclone.MountOptions, mode string) {
+	o = o.withDefaults(mode)
+
+	if o.ReadChunkSize != "" {
+		options.Set("--vfs-read-chunk-size", o.ReadChunkSize)
+	}
+
+	if o.ReadChunkSizeLimit != "" {
+		options.Set("--vfs-read-chunk-size-limit", o.ReadChunkSizeLimit)
+	}
+
+	if strings.EqualFold(mode, "off") {
+		return
+	}
+
+	if o.MaxSize != "" {
+		options.Set("--vfs-cache-max-size", o.MaxSize)
+	}
+
+	if o.MaxAge != 0 {
+		options.Set("--vfs-cache-max-age", o.MaxAge.String())
+	}
+
+	if o.PollInterval != 0 {
+		options.Set("--vfs-cache-poll-interval", o.PollInterval.String())
+	}
+
+	if o.ReadAhead != "" && strings.EqualFold(mode, "full") {
+		options.Set("--vfs-read-ahead", o.ReadAhead)
+	}
+
+	if o.WriteBack != 0 {
+		options.Set("--vfs-write-back", o.WriteBack.String())
+	}
+
+	if o.Transfers != 0 {
+		options.Set("--transfers", strconv.Itoa(o.Transfers))
+	}
+}
+
+// prepareCacheDir deletes the cache folder of a previous mount, unless it has
+// to be kept or it holds files not uploaded yet: rclone uploads them again
+// mounting with the same cache folder.
+func prepareCacheDir(serverInstance *Server) error {
+	cacheDir := serverInstance.LocalCacheDir
+	cacheOff := strings.EqualFold(serverInstance.LocalCache, "off")
+
+	dirtyFiles, err := rclone.DirtyFiles(cacheDir)
+	if err != nil {
+		log.Warn().Err(err).Str("cacheDir", cacheDir).Msg("cache - cannot check the files not uploaded")
+
+		// do not delete what cannot be checked
+		return nil
+	}
+
+	if len(dirtyFiles) != 0 {
+		log.Warn().Strs("files", dirtyFiles).Str("cacheDir", cacheDir).Msg("cache - files not uploaded")
+		color.Yellow.Printf("==> The local cache %s holds %d files not uploaded yet, it is not deleted\n",
+			cacheDir, len(dirtyFiles))
+
+		if cacheOff {
+			color.Yellow.Println("==> Mount with localCache writes or full to upload them")
+		} else {
+			color.Yellow.Println("==> The uploads are resumed now")
+		}
+
+		return nil
+	}
+
+	if cacheOff || serverInstance.Cache.Keep {
+		return nil
+	}
+
+	if err := os.RemoveAll(cacheDir); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cache dir %w", err)
+	}
+
+	return nil
+}
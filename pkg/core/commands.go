@@ -3,7 +3,6 @@ package core
 import (
 	"bufio"
 	"bytes"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,9 +12,13 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/DODAS-TS/sts-wire/pkg/oidc"
+	"github.com/DODAS-TS/sts-wire/pkg/rclone"
+	"github.com/DODAS-TS/sts-wire/pkg/redact"
 	"github.com/DODAS-TS/sts-wire/pkg/template"
 	"github.com/DODAS-TS/sts-wire/pkg/validator"
 	"github.com/gookit/color"
@@ -37,7 +40,6 @@ const (
 '##::: ##:::: ##::::'##::: ##:::::::::: ##: ##: ##:: ##:: ##::. ##:: ##:::::::
 . ######::::: ##::::. ######:::::::::::. ###. ###::'####: ##:::. ##: ########:
 :......::::::..::::::......:::::::::::::...::...:::....::..:::::..::........::`
-	minNumArgs = 4
 )
 
 // Execute of the sts-wire command.
@@ -50,8 +52,9 @@ func Execute() {
 }
 
 const (
-	errNumArgsS          = "requires the following arguments: <instance name> <s3 endpoint> <rclone remote path> <local mount point>"
-	numAcceptedArguments = 4
+	errNumArgsS = "requires the following arguments: <IAM server> <instance name> <s3 endpoint> <rclone remote path> " +
+		"<local mount point>, only <rclone remote path> <local mount point> with a profile, " +
+		"or none to read them from the config file"
 )
 
 var (
@@ -70,76 +73,98 @@ var (
 	localCacheDir     string //nolint:gochecknoglobals
 	readOnly          bool   //nolint:gochecknoglobals
 	tryRemount        bool   //nolint:gochecknoglobals
+	allowNonEmpty     bool   //nolint:gochecknoglobals
+	unmountStale      bool   //nolint:gochecknoglobals
+	noTokenVerify     bool   //nolint:gochecknoglobals
+	unsafeLogSecrets  bool   //nolint:gochecknoglobals
+	caFile            string //nolint:gochecknoglobals
+	caDir             string //nolint:gochecknoglobals
+	clientCert        string //nolint:gochecknoglobals
+	clientKey         string //nolint:gochecknoglobals
+	proxy             string //nolint:gochecknoglobals
+	noProxy           string //nolint:gochecknoglobals
+	ipVersion         string //nolint:gochecknoglobals
+	configForce       bool   //nolint:gochecknoglobals
+	configSources     bool   //nolint:gochecknoglobals
+	profile           string //nolint:gochecknoglobals
+	profilesFile      string //nolint:gochecknoglobals
+	cacheMaxSize      string //nolint:gochecknoglobals
+	keepCache         bool   //nolint:gochecknoglobals
+	bwLimit           string //nolint:gochecknoglobals
+	refreshRecursive  bool   //nolint:gochecknoglobals
 	errNumArgs        = errors.New(errNumArgsS)
 
 	// rootCmd the sts-wire command.
 	rootCmd = &cobra.Command{ //nolint:exhaustivestruct,gochecknoglobals
-		Use:   "sts-wire <IAM server> <instance name> <s3 endpoint> <rclone remote path> <local mount point>",
+		Use:   "sts-wire [<IAM server> <instance name> <s3 endpoint>] <rclone remote path> <local mount point>",
 		Short: "",
 		Args: func(cmd *cobra.Command, args []string) error {
-			if cfgFile == "" {
-				if len(args) < minNumArgs {
-					return errNumArgs
-				}
-				if len(args) == numAcceptedArguments {
-					if validIAMServer, err := validator.WebURL(os.Args[1]); !validIAMServer {
-						panic(err)
-					}
-					if validInstanceName, err := validator.InstanceName(os.Args[2]); !validInstanceName {
-						panic(err)
-					}
-					if validEndpoint, err := validator.S3Endpoint(os.Args[3]); !validEndpoint {
-						panic(err)
-					}
-					if validRemotePath, err := validator.RemotePath(os.Args[4]); !validRemotePath {
-						panic(err)
-					}
-					if validLocalPath, err := validator.LocalPath(os.Args[5]); !validLocalPath {
-						panic(err)
-					}
-				}
+			if len(args) != 0 && len(args) != len(shortPositionalKeys) && len(args) != len(positionalKeys) {
+				return errNumArgs
 			}
 
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if confLog := viper.GetString("log"); logFile == defaultLogFile && confLog != "" {
-				logFile = confLog
+			cfg, errConfig := LoadConfig(cmd.Flags(), args)
+			if errConfig != nil {
+				printConfigErrors(errConfig)
+				panic(errConfig)
 			}
 
 			if debug {
-				logFile = "stderr"
+				cfg.Log = "stderr"
+			}
+
+			if errValidate := cfg.Validate(); errValidate != nil {
+				printConfigErrors(errValidate)
+				panic(errValidate)
+			}
+
+			currentConfig = cfg
+
+			if cfg.File() != "" {
+				fmt.Printf("==> sts-wire is using config file: %s\n", cfg.File())
+			}
+
+			if unsafeLogSecrets {
+				redact.Disable()
+				color.Red.Println("==> WARNING: the secrets are written in clear in the logs, do not share them!")
 			}
 
 			var firstLogWriter *os.File
 
-			if logFile != "stderr" {
-				if valid, err := validator.LogFile(logFile); !valid {
-					panic(err)
-				}
-				_, errBaseDir := os.Stat(filepath.Dir(logFile))
+			if cfg.Log != "stderr" {
+				_, errBaseDir := os.Stat(filepath.Dir(cfg.Log))
 				if errBaseDir != nil && os.IsNotExist(errBaseDir) {
-					errMkdirs := os.MkdirAll(filepath.Dir(logFile), os.ModePerm)
+					errMkdirs := os.MkdirAll(filepath.Dir(cfg.Log), os.ModePerm)
 					if errMkdirs != nil {
 						panic(errMkdirs)
 					}
 				}
 
-				logTarget, errOpenLog := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, fileMode)
+				logTarget, errOpenLog := os.OpenFile(cfg.Log, os.O_APPEND|os.O_WRONLY|os.O_CREATE, fileMode)
 				if errOpenLog != nil {
 					panic(errOpenLog)
 				}
 
 				firstLogWriter = logTarget
-				log.Logger = zerolog.New(logTarget).With().Timestamp().Logger()
+				log.Logger = zerolog.New(redact.NewWriter(logTarget)).With().Timestamp().Logger()
 				defer logTarget.Close()
 			} else {
-				log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr}) // nolint:exhaustivestruct
+				log.Logger = log.Output(redact.NewWriter(zerolog.ConsoleWriter{Out: os.Stderr})) // nolint:exhaustivestruct
 			}
 
-			log.Debug().Str("log file", logFile).Msg("logging")
+			setLogLevel(cfg.LogLevel)
+
+			log.Debug().Str("log file", cfg.Log).Msg("logging")
 			log.Debug().Msg("Start sts-wire")
 
+			for _, key := range cfg.Unknown() {
+				log.Warn().Str("key", key).Str("file", cfg.File()).Msg("command - unknown config key")
+				color.Yellow.Printf("==> Unknown key in the config file: %s\n", key)
+			}
+
 			if runtime.GOOS == "linux" {
 				fuseCmd := exec.Command("fusermount", "-V")
 
@@ -156,106 +181,42 @@ var (
 				Scanner: inputReader,
 			}
 
-			var (
-				iamServer      string
-				instance       string
-				confDir        string
-				s3Endpoint     string
-				remote         string
-				localMountPath string
-			)
-
-			if cfgFile != "" {
-				iamServer = viper.GetString("IAM_Server")
-				instance = viper.GetString("instance_name")
-				s3Endpoint = viper.GetString("s3_endpoint")
-				remote = viper.GetString("rclone_remote_path")
-				localMountPath = viper.GetString("local_mount_point")
-			} else {
-				iamServer = os.Args[1]
-				instance = os.Args[2]
-				s3Endpoint = os.Args[3]
-				remote = os.Args[4]
-				localMountPath = os.Args[5]
+			if errMountPoint := prepareMountPoint(cfg, &Wizard{Scanner: scanner}); errMountPoint != nil { // nolint:exhaustivestruct
+				color.Red.Printf("==> %s\n", errMountPoint)
+				panic(errMountPoint)
 			}
 
-			// TODO: check if it is useful or not to have env variable overwrite mechanism
-			// ENV VARIABLE OVERWRITE
-			if os.Getenv("IAM_SERVER") != "" {
-				iamServer = os.Getenv("IAM_SERVER")
-			}
+			var confDir string
 
-			noPWD = noPWD || viper.GetBool("noPassword")
-			noModtime = noModtime || viper.GetBool("noModtime")
-			noDummyFileCheck = noDummyFileCheck || viper.GetBool("noDummyFileCheck")
-			if localCache == "" {
-				localCache = viper.GetString("localCache")
-			}
-			if localCacheDir == "" {
-				localCacheDir = viper.GetString("localCacheDir")
-			}
-			readOnly = readOnly || viper.GetBool("readOnly")
-
-			if confTryRemount := viper.Get("tryRemount"); confTryRemount != nil && confTryRemount.(bool) == false {
-				tryRemount = false
-			}
+			iamServer := cfg.IAMServer
+			instance := cfg.InstanceName
+			s3Endpoint := cfg.S3Endpoint
+			remote := cfg.RcloneRemotePath
+			localMountPath := cfg.LocalMountPoint
 
 			log.Debug().Str("iamServer", iamServer).Msg("command")
 			log.Debug().Str("istance", instance).Msg("command")
 			log.Debug().Str("s3Endpoint", s3Endpoint).Msg("command")
 			log.Debug().Str("remote", remote).Msg("command")
 			log.Debug().Str("localMountPath", localMountPath).Msg("command")
-			log.Debug().Bool("noPassword", noPWD).Msg("command")
-			log.Debug().Bool("noModtime", noModtime).Msg("command")
-			log.Debug().Bool("noDummyFileCheck", noDummyFileCheck).Msg("command")
-			log.Debug().Str("localCache", localCache).Msg("command")
-			log.Debug().Str("localCacheDir", localCacheDir).Msg("command")
-			log.Debug().Bool("readOnly", readOnly).Msg("command")
-			log.Debug().Bool("tryRemount", tryRemount).Msg("command")
-
-			switch localCache {
-			// valid: off,minimal,writes,full
-			case "off", "OFF", "minimal", "MINIMAL", "writes", "WRITES", "full", "FULL":
-				log.Debug().Str("localCache", localCache).Msg("command - valid parameter")
-			default:
-				panic(fmt.Errorf("not a valid localCache parameter %s", localCache))
-			}
-
-			if cfgFile != "" {
-				if validIAMServer, err := validator.WebURL(iamServer); !validIAMServer {
-					panic(fmt.Errorf("not a valid IAM server %w", err))
-				}
-				if validInstanceName, err := validator.InstanceName(instance); !validInstanceName {
-					panic(err)
-				}
-				if validEndpoint, err := validator.S3Endpoint(s3Endpoint); !validEndpoint {
-					panic(err)
-				}
-				if validRemotePath, err := validator.RemotePath(remote); !validRemotePath {
-					panic(err)
-				}
-				if validLocalPath, err := validator.LocalPath(localMountPath); !validLocalPath {
-					panic(err)
-				}
-				if validLocalCacheDir, err := validator.LocalPath(localCacheDir); !validLocalCacheDir {
-					panic(err)
-				}
-			}
+			log.Debug().Bool("noPassword", cfg.NoPassword).Msg("command")
+			log.Debug().Bool("noModtime", cfg.NoModtime).Msg("command")
+			log.Debug().Bool("noDummyFileCheck", cfg.NoDummyFileCheck).Msg("command")
+			log.Debug().Str("localCache", cfg.LocalCache).Msg("command")
+			log.Debug().Str("localCacheDir", cfg.LocalCacheDir).Msg("command")
+			log.Debug().Interface("cache", cfg.Cache).Msg("command")
+			log.Debug().Interface("bandwidth", cfg.Bandwidth).Msg("command")
+			log.Debug().Interface("refresh", cfg.Refresh).Msg("command")
+			log.Debug().Bool("readOnly", cfg.ReadOnly).Msg("command")
+			log.Debug().Bool("tryRemount", cfg.TryRemount).Msg("command")
+			log.Debug().Bool("allowNonEmpty", cfg.AllowNonEmpty).Msg("command")
+			log.Debug().Bool("noTokenVerify", cfg.NoTokenVerify).Msg("command")
 
 			// -------------------- CONFIG IAM URL AND PORT --------------------
-			iamcURL := "localhost"
-			var iamcPort int
+			iamcURL := cfg.IAMAuthURL
+			iamcPort := cfg.IAMAuthURLPort
 
-			if newIamcURL := viper.GetString("IAMAuthURL"); newIamcURL != "" {
-				if valid, err := validator.WebURL(newIamcURL); !valid || err != nil {
-					panic(err)
-				}
-				iamcURL = newIamcURL
-			}
-
-			if newIamcPort := viper.GetInt("IAMAuthURLPort"); newIamcPort > 0 {
-				iamcPort = newIamcPort
-			} else {
+			if iamcPort == 0 {
 				// select a random port available from the OS
 				randomPort, errRandPort := availableRandomPort()
 				if errRandPort != nil {
@@ -277,10 +238,45 @@ var (
 			log.Debug().Str("iamcURL", iamcURL).Msg("command")
 			log.Debug().Int("iamcPort", iamcPort).Msg("command")
 
+			// ------------------------- CONFIG OAUTH --------------------------
+			oauthOptions := cfg.OAuthOptions()
+
+			log.Debug().Strs("scopes", oauthOptions.ScopeList()).Strs("audience",
+				oauthOptions.Audience).Interface("authParams", oauthOptions.ExtraParams).Msg("command")
+
+			tokenExchange := cfg.TokenExchange
+
+			log.Debug().Bool("enabled", tokenExchange.Enabled).Strs("audience",
+				tokenExchange.Audience).Strs("scopes", tokenExchange.Scopes).Str("requestedTokenType",
+				tokenExchange.RequestedTokenType).Msg("command - token exchange")
+
+			// -------------------------- CONFIG STS ---------------------------
+			stsConfig := cfg.STSConfig()
+
+			switch stsConfig.Action {
+			case STSActionLDAPIdentity:
+				stsConfig.LDAPPassword = stsSecret(&scanner, "LDAP_PASSWORD", "Insert the LDAP password")
+			case STSActionAssumeRole:
+				stsConfig.SecretKey = stsSecret(&scanner, "STS_SECRET_KEY", "Insert the secret key")
+			}
+
+			policy, errPolicy := cfg.STSPolicy()
+			if errPolicy != nil {
+				panic(errPolicy)
+			}
+
+			stsConfig.Policy = policy
+
+			log.Debug().Str("action", stsConfig.Action).Str("ldapUsername",
+				stsConfig.LDAPUsername).Str("accessKey", stsConfig.AccessKey).Str("roleArn",
+				stsConfig.RoleArn).Str("roleSessionName", stsConfig.RoleSessionName).Str("policyFile",
+				cfg.STSPolicyFile).Msg("command - STS")
+
 			clientConfig := IAMClientConfig{ // nolint:exhaustivestruct
 				Host:       iamcURL,
 				Port:       iamcPort,
 				ClientName: "oidc-client",
+				Scope:      oauthOptions.Scope(),
 			}
 
 			// ------------------------ CONFIG INSTANCE ------------------------
@@ -318,7 +314,7 @@ var (
 					instanceLogFile,
 				)
 			}
-			log.Logger = zerolog.New(multi).With().Timestamp().Logger()
+			log.Logger = zerolog.New(redact.NewWriter(multi)).With().Timestamp().Logger()
 
 			// ---------------------- CONFIG INSTANCE INFO ---------------------
 			instanceFilename := path.Join(confDir, "instance.info")
@@ -334,7 +330,7 @@ var (
 					Name:     instance,
 					LogFile:  "./instance.log",
 					Port:     iamcPort,
-					Password: !noPWD,
+					Password: !cfg.NoPassword,
 				}, "", "  ")
 
 				if errMarshall != nil {
@@ -371,53 +367,44 @@ var (
 				}
 			}
 
-			// ------------------- CONFIG REFRESH TOKEN INFO -------------------
-			if newRefreshTokenRenew := viper.GetInt("refreshTokenRenew"); newRefreshTokenRenew != 0 && refreshTokenRenew == 15 {
-				refreshTokenRenew = newRefreshTokenRenew
-			}
-
-			if valid, errRefreshToken := validator.RefreshTokenRenew(refreshTokenRenew); errRefreshToken != nil || !valid {
-				panic(errRefreshToken)
-			}
+			// ---------------------- CONFIG STATIC CLIENT ---------------------
+			staticClient := cfg.StaticClient()
 
-			log.Debug().Int("refreshTokenRenew", refreshTokenRenew).Msg("command")
-			log.Debug().Str("rcloneMountFlags", rcloneMountFlags).Msg("command")
+			callbackPath := defaultCallbackPath
 
-			if rcloneMountFlags != "" {
-				if validMountFlags, err := validator.RcloneMountFlags(rcloneMountFlags); !validMountFlags {
-					panic(fmt.Errorf("not valid rclone mount flags %w", err))
+			if staticClient.Enabled() && staticClient.RedirectURI != "" {
+				redirectPort, redirectPath, errCallback := staticClient.Callback()
+				if errCallback != nil {
+					panic(errCallback)
 				}
+
+				clientConfig.Port = redirectPort
+				callbackPath = redirectPath
 			}
 
-			// Create a CA certificate pool and add cert.pem to it
-			// TODO: convert ioutil.ReadFile to os
-			// 		 ref: https://www.srcbeat.com/2021/01/golang-ioutil-deprecated/
-			// caCert, err := ioutil.ReadFile("MINIO.pem")
-			// if err != nil {
-			//	log.Fatal(err)
-			// }
-			// caCertPool := x509.NewCertPool()
-			// caCertPool.AppendCertsFromPEM(caCert)
+			log.Debug().Bool("staticClient", staticClient.Enabled()).Bool("publicClient",
+				staticClient.Public()).Str("redirectURI", staticClient.RedirectURI).Msg("command")
 
-			// Create the TLS Config with the CA pool and enable Client certificate validation
+			// ------------------- CONFIG REFRESH TOKEN INFO -------------------
+			log.Debug().Int("refreshTokenRenew", cfg.RefreshTokenRenew).Msg("command")
+			log.Debug().Str("rcloneMountFlags", cfg.RcloneMountFlags).Msg("command")
 
-			if insecureConnVip := viper.GetBool("insecureConn"); insecureConnVip != insecureConn {
-				insecureConn = insecureConnVip
-			}
-			log.Debug().Bool("insecureConn", insecureConn).Msg("command")
+			// ------------------------- CONFIG TLS ----------------------------
+			tlsConfig := cfg.TLSConfig()
 
-			cfg := &tls.Config{ // nolint: exhaustivestruct
-				// ClientCAs: caCertPool,
-				InsecureSkipVerify: insecureConn, // nolint:gosec
-			}
-			// cfg.BuildNameToCertificate()
+			log.Debug().Bool("insecureConn", tlsConfig.Insecure).Str("caFile", tlsConfig.CAFile).Str("caDir",
+				tlsConfig.CADir).Str("clientCert", tlsConfig.ClientCert).Msg("command")
 
-			tr := &http.Transport{ // nolint:exhaustivestruct
-				TLSClientConfig: cfg,
-			}
+			// ----------------------- CONFIG NETWORK --------------------------
+			networkConfig := cfg.NetworkConfig()
 
-			httpClient := &http.Client{ // nolint:exhaustivestruct
-				Transport: tr,
+			log.Debug().Bool("proxy", networkConfig.explicitProxy()).Str("noProxy",
+				networkConfig.NoProxy).Dur("connectTimeout", networkConfig.ConnectTimeout).Dur("responseTimeout",
+				networkConfig.ResponseTimeout).Str("ipVersion", networkConfig.IPVersion).Msg("command")
+
+			httpClient, errHTTPClient := newHTTPClient(tlsConfig, networkConfig)
+			if errHTTPClient != nil {
+				panic(errHTTPClient)
 			}
 
 			clientIAM := InitClientConfig{
@@ -427,17 +414,33 @@ var (
 				HTTPClient:     *httpClient,
 				IAMServer:      iamServer,
 				ClientTemplate: template.ClientTemplate,
-				NoPWD:          noPWD,
+				NoPWD:          cfg.NoPassword,
 			}
 
-			clientResponse := ClientResponse {}
+			clientResponse := ClientResponse{}
 			endpoint := iamServer
 
-			if os.Getenv("REFRESH_TOKEN") != "" {
+			switch {
+			case !stsConfig.NeedsToken(): // No IAM client needed
+				log.Debug().Str("action", stsConfig.Action).Msg("command - no IAM client")
+			case stsConfig.Action == STSActionClientGrants && os.Getenv("REFRESH_TOKEN") == "":
+				if !staticClient.Enabled() || staticClient.Public() {
+					panic(errClientGrantsNoClient)
+				}
+
+				color.Green.Printf("==> IAM static client used: %s\n", staticClient.ClientID)
+
+				clientResponse = staticClient.ClientResponse(iamServer)
+			case os.Getenv("REFRESH_TOKEN") != "":
 				clientResponse.ClientID = os.Getenv("IAM_CLIENT_ID")
 				clientResponse.ClientSecret = os.Getenv("IAM_CLIENT_SECRET")
 				clientResponse.Endpoint = iamServer
-			} else { // Client registration
+			case staticClient.Enabled(): // Pre-registered client
+				log.Debug().Str("clientID", staticClient.ClientID).Msg("command - static client")
+				color.Green.Printf("==> IAM static client used: %s\n", staticClient.ClientID)
+
+				clientResponse = staticClient.ClientResponse(iamServer)
+			default: // Client registration
 				iamEndpoint, iamClientResponse, _, err := clientIAM.InitClient(instance)
 				if err != nil {
 					panic(err)
@@ -449,22 +452,55 @@ var (
 				endpoint = iamEndpoint
 			}
 
+			var provider *oidc.ProviderMetadata
+
+			if stsConfig.NeedsToken() {
+				provider = DiscoverProvider(httpClient, endpoint)
+				oauthOptions.CheckSupported(provider)
+			}
+
 			server := Server{
-				Client:            clientIAM,
-				Instance:          instance,
-				S3Endpoint:        s3Endpoint,
-				RemotePath:        remote,
-				LocalPath:         localMountPath,
-				Endpoint:          endpoint,
-				CurClientResponse: clientResponse,
-				RefreshTokenRenew: refreshTokenRenew,
-				ReadOnly:          readOnly,
-				NoModtime:         noModtime,
-				NoDummyFileCheck:  noDummyFileCheck,
-				LocalCache:        localCache,
-				LocalCacheDir:     localCacheDir,
-				MountNewFlags:     rcloneMountFlags,
-				TryRemount:        tryRemount,
+				Client:              clientIAM,
+				Instance:            instance,
+				S3Endpoint:          s3Endpoint,
+				RemotePath:          remote,
+				LocalPath:           localMountPath,
+				Endpoint:            endpoint,
+				CurClientResponse:   clientResponse,
+				RefreshTokenRenew:   cfg.RefreshTokenRenew,
+				ReadOnly:            cfg.ReadOnly,
+				NoModtime:           cfg.NoModtime,
+				NoDummyFileCheck:    cfg.NoDummyFileCheck,
+				LocalCache:          cfg.LocalCache,
+				LocalCacheDir:       cfg.LocalCacheDir,
+				Cache:               cfg.Cache,
+				Bandwidth:           cfg.Bandwidth,
+				Refresh:             cfg.Refresh,
+				MountOptions:        cfg.MountOptions(),
+				RcloneProvider:      cfg.RcloneProvider,
+				TryRemount:          cfg.TryRemount,
+				AllowNonEmpty:       cfg.AllowNonEmpty,
+				HealthCheckInterval: cfg.HealthCheckInterval,
+				ShutdownTimeout:     cfg.ShutdownTimeout,
+				RedirectURL:         staticClient.RedirectURI,
+				CallbackPath:        callbackPath,
+				OAuth:               oauthOptions,
+				TokenExchange:       tokenExchange,
+				PublicClient:        staticClient.Public(),
+				Provider:            provider,
+				NoTokenVerify:       cfg.NoTokenVerify,
+				STS:                 stsConfig,
+				TLS:                 tlsConfig,
+				Network:             networkConfig,
+				config:              cfg,
+				reloadConfig: func() (Config, error) {
+					newCfg, err := LoadConfig(cmd.Flags(), args)
+					if debug {
+						newCfg.Log = "stderr"
+					}
+
+					return newCfg, err
+				},
 			}
 
 			credsIAM, endpoint, errStart := server.Start()
@@ -472,9 +508,11 @@ var (
 				panic(errStart)
 			}
 
-			if refreshToken := os.Getenv("REFRESH_TOKEN"); refreshToken != "" {
-				log.Debug().Str("refreshToken", refreshToken).Msg("Force refresh token call")
-				server.RefreshToken(credsIAM, endpoint)
+			if refreshToken := os.Getenv("REFRESH_TOKEN"); refreshToken != "" && stsConfig.Action == STSActionWebIdentity {
+				log.Debug().Msg("Force refresh token call")
+				if errRefresh := server.RefreshToken(&credsIAM, endpoint); errRefresh != nil {
+					panic(errRefresh)
+				}
 			}
 
 			color.Green.Printf("==> Server started successfully and volume mounted at %s\n", localMountPath)
@@ -535,6 +573,376 @@ var (
 		},
 	}
 
+	whoamiCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "whoami [token file]",
+		Short: "Print the subject, groups, scopes and expiry of the current access token",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tokenFile := ".token"
+			if len(args) == 1 {
+				tokenFile = args[0]
+			}
+
+			token, err := os.ReadFile(tokenFile)
+			if err != nil {
+				panic(err)
+			}
+
+			cfg, err := LoadConfig(cmd.Flags(), nil)
+			if err != nil {
+				panic(err)
+			}
+
+			httpClient, err := newHTTPClient(cfg.TLSConfig(), cfg.NetworkConfig())
+			if err != nil {
+				panic(err)
+			}
+
+			fmt.Println(buildWhoami(httpClient, string(token), cfg.IAMAudience))
+		},
+	}
+
+	initCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "init [config file]",
+		Short: "Create a config file with an interactive wizard (default ~/.sts-wire/config.yml)",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			configFilename := defaultWizardFile()
+			if len(args) == 1 {
+				configFilename = args[0]
+			}
+
+			wizard := Wizard{
+				Scanner: GetInputWrapper{
+					Scanner: *bufio.NewReader(os.Stdin),
+				},
+			}
+
+			if _, err := os.Stat(configFilename); err == nil && !configForce {
+				if !wizard.confirm(fmt.Sprintf("The config file %s exists, overwrite it?", configFilename), false) {
+					panic(errWizardAborted)
+				}
+			}
+
+			// The current flags and config file are used only for the connection
+			// checks of the wizard.
+			cfg, errConfig := LoadConfig(cmd.Flags(), nil)
+			if errConfig != nil {
+				cfg = DefaultConfig()
+			}
+
+			httpClient, err := newHTTPClient(cfg.TLSConfig(), cfg.NetworkConfig())
+			if err != nil {
+				panic(err)
+			}
+
+			wizard.HTTPClient = httpClient
+
+			newConfig := wizard.Run()
+
+			if errValidate := newConfig.Validate(); errValidate != nil {
+				printConfigErrors(errValidate)
+
+				if !wizard.confirm("Write the config file anyway?", false) {
+					panic(errWizardAborted)
+				}
+			}
+
+			if err := writeWizardConfig(newConfig, configFilename); err != nil {
+				panic(err)
+			}
+
+			color.Green.Printf("==> Config file written: %s\n", configFilename)
+
+			if configFilename == defaultWizardFile() {
+				color.Green.Println("==> Start the mount with: sts-wire")
+			} else {
+				color.Green.Printf("==> Start the mount with: sts-wire --config %s\n", configFilename)
+			}
+		},
+	}
+
+	configCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "config",
+		Short: "Create, validate and show the sts-wire configuration",
+	}
+
+	configInitCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "init [config file]",
+		Short: "Write a config file with the default values and the description of each key",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			configFilename := defaultConfigFile
+			if len(args) == 1 {
+				configFilename = args[0]
+			}
+
+			if _, err := os.Stat(configFilename); err == nil && !configForce {
+				panic(fmt.Errorf("%w: %s, use --force to overwrite it", errConfigExists, configFilename))
+			}
+
+			document, err := DefaultConfig().YAML(true, false)
+			if err != nil {
+				panic(err)
+			}
+
+			if err := os.WriteFile(configFilename, document, configFileMode); err != nil {
+				panic(err)
+			}
+
+			color.Green.Printf("==> Config file written: %s\n", configFilename)
+		},
+	}
+
+	configValidateCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "validate [[<IAM server> <instance name> <s3 endpoint>] <rclone remote path> <local mount point>]",
+		Short: "Check the effective configuration and print all the errors",
+		Args:  rootCmd.Args,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := LoadConfig(cmd.Flags(), args)
+			if err == nil {
+				err = cfg.Validate()
+			}
+
+			for _, key := range cfg.Unknown() {
+				color.Yellow.Printf("==> Unknown key in the config file: %s\n", key)
+			}
+
+			if err != nil {
+				printConfigErrors(err)
+				os.Exit(1)
+			}
+
+			color.Green.Println("==> The configuration is valid")
+		},
+	}
+
+	configShowCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "show [[<IAM server> <instance name> <s3 endpoint>] <rclone remote path> <local mount point>]",
+		Short: "Print the effective configuration merged from flags, environment, config file and defaults",
+		Args:  rootCmd.Args,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := LoadConfig(cmd.Flags(), args)
+			if err != nil {
+				printConfigErrors(err)
+				os.Exit(1)
+			}
+
+			if configSources {
+				for _, field := range cfg.fields() {
+					fmt.Printf("%-32s %s\n", field.key, cfg.Source(field.key))
+				}
+
+				return
+			}
+
+			document, err := cfg.YAML(false, true)
+			if err != nil {
+				panic(err)
+			}
+
+			fmt.Print(string(document))
+		},
+	}
+
+	configProfilesCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "profiles",
+		Short: "List the built-in and the user profiles",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			profiles, err := Profiles()
+			if err != nil {
+				panic(err)
+			}
+
+			for _, name := range ProfileNames(profiles) {
+				profile := profiles[name]
+
+				color.Green.Printf("==> %s", name)
+				fmt.Printf(" (%s) %s\n", profile.Source, profile.Description)
+
+				keys := make([]string, 0, len(profile.Settings))
+				for key := range profile.Settings {
+					keys = append(keys, key)
+				}
+
+				sort.Strings(keys)
+
+				for _, key := range keys {
+					fmt.Printf("    %-20s %v\n", key, redact.Value(key, profile.Settings[key]))
+				}
+			}
+		},
+	}
+
+	reloadCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "reload [instance name]",
+		Short: "Reload the configuration of a running instance, like sending SIGHUP",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			instance := ""
+			if len(args) == 1 {
+				instance = args[0]
+			} else {
+				cfg, err := LoadConfig(cmd.Flags(), nil)
+				if err != nil {
+					printConfigErrors(err)
+					os.Exit(1)
+				}
+
+				instance = cfg.InstanceName
+			}
+
+			if _, err := validator.InstanceName(instance); err != nil {
+				color.Red.Printf("==> %s\n", err)
+				os.Exit(1)
+			}
+
+			var result ReloadResult
+			if err := controlRequest("."+instance, http.MethodPost, "/reload", nil, &result); err != nil {
+				color.Red.Printf("==> Configuration not reloaded: %s\n", err)
+				os.Exit(1)
+			}
+
+			color.Green.Printf("==> Configuration of %s reloaded\n", instance)
+
+			for _, key := range result.Applied {
+				fmt.Printf("    applied: %s\n", key)
+			}
+
+			if result.Remounted {
+				fmt.Println("    the volume was mounted again")
+			}
+
+			for _, key := range result.RestartRequired {
+				fmt.Printf("    restart required: %s\n", key)
+			}
+		},
+	}
+
+	bwLimitCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "bwlimit <instance name> [rate]",
+		Short: "Show or change the bandwidth limit of a running instance, e.g. 10M, 10M:off or off, not a timetable",
+		Args:  cobra.RangeArgs(1, 2), // nolint:gomnd
+		Run: func(cmd *cobra.Command, args []string) {
+			instance := args[0]
+			if _, err := validator.InstanceName(instance); err != nil {
+				color.Red.Printf("==> %s\n", err)
+				os.Exit(1)
+			}
+
+			method, request := http.MethodGet, interface{}(nil)
+			if len(args) == 2 { // nolint:gomnd
+				method, request = http.MethodPost, bwLimitRequest{Rate: args[1]}
+			}
+
+			var limit rclone.BwLimit
+			if err := controlRequest("."+instance, method, "/bwlimit", request, &limit); err != nil {
+				color.Red.Printf("==> Bandwidth limit not available: %s\n", err)
+				os.Exit(1)
+			}
+
+			color.Green.Printf("==> Bandwidth limit of %s: %s\n", instance, limit.Rate)
+		},
+	}
+
+	refreshDirCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "refresh-dir <instance name> [dir]",
+		Short: "Read again from the bucket a directory of a running instance, the root if not given",
+		Long: `Forget the cached listing of a directory and read it again from the bucket,
+to see the files uploaded by others before the directory cache expires.
+The directory is an absolute path inside the local mount point or a path
+relative to the root of the mount.`,
+		Args: cobra.RangeArgs(1, 2), // nolint:gomnd
+		Run: func(cmd *cobra.Command, args []string) {
+			instance := args[0]
+			if _, err := validator.InstanceName(instance); err != nil {
+				color.Red.Printf("==> %s\n", err)
+				os.Exit(1)
+			}
+
+			request := refreshRequest{Recursive: refreshRecursive}
+			if len(args) == 2 { // nolint:gomnd
+				request.Dir = args[1]
+			}
+
+			var result map[string]string
+			if err := controlRequest("."+instance, http.MethodPost, "/refresh", request, &result); err != nil {
+				color.Red.Printf("==> Directory not refreshed: %s\n", err)
+				os.Exit(1)
+			}
+
+			failed := false
+
+			for dir, status := range result {
+				if dir == "" {
+					dir = "/"
+				}
+
+				if status != refreshOK {
+					color.Red.Printf("==> %s: %s\n", dir, status)
+
+					failed = true
+
+					continue
+				}
+
+				color.Green.Printf("==> %s refreshed\n", dir)
+			}
+
+			if failed {
+				os.Exit(1)
+			}
+		},
+	}
+
+	umountCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "umount <instance name|mount point>",
+		Short: "Stop a running instance or unmount the mount points left by an instance, e.g. after a crash",
+		Long: `Stop a running instance like Ctrl+C, waiting for the uploads of the local
+cache, or unmount the mount points left by an instance or a mount point.
+The mount point is unmounted with fusermount or umount and, if busy, with
+a lazy unmount, after listing the processes that use it.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			target := args[0]
+
+			var err error
+
+			if _, errConfDir := os.Stat("." + target); errConfDir == nil && !strings.ContainsRune(target, os.PathSeparator) {
+				err = umountInstance(target)
+			} else {
+				err = umountPath(target)
+			}
+
+			if err != nil {
+				color.Red.Printf("==> %s\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	statsCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
+		Use:   "stats <instance name>",
+		Short: "Show the transfers, the cache usage and the errors of a running instance",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			instance := args[0]
+			if _, err := validator.InstanceName(instance); err != nil {
+				color.Red.Printf("==> %s\n", err)
+				os.Exit(1)
+			}
+
+			var stats instanceStats
+			if err := controlRequest("."+instance, http.MethodGet, "/stats", nil, &stats); err != nil {
+				color.Red.Printf("==> Stats not available: %s\n", err)
+				os.Exit(1)
+			}
+
+			printStats(instance, stats)
+		},
+	}
+
 	reportCmd = &cobra.Command{ // nolint:exhaustivestruct,gochecknoglobals
 		Use:   "report",
 		Short: "search and open sts-wire reports",
@@ -565,6 +973,20 @@ var (
 	}
 )
 
+// printConfigErrors prints the configuration errors, one per line.
+func printConfigErrors(err error) {
+	var errs validator.Errors
+	if !errors.As(err, &errs) {
+		errs = validator.Errors{err}
+	}
+
+	color.Red.Printf("==> The configuration is not valid:\n")
+
+	for _, curErr := range errs {
+		color.Red.Printf("    - %s\n", curErr)
+	}
+}
+
 func reportCompleter(d prompt.Document) []prompt.Suggest {
 	suggestions := []prompt.Suggest{}
 
@@ -597,6 +1019,45 @@ func buildCmdVersion() string {
 	return versionString.String()
 }
 
+// stsSecret returns the secret of the STS action from the environment variable
+// or asks it to the user.
+func stsSecret(scanner *GetInputWrapper, envName string, question string) string {
+	if secret := os.Getenv(envName); secret != "" {
+		return secret
+	}
+
+	passMsg := fmt.Sprintf("%s %s: ", color.Yellow.Sprint("==>"), question)
+
+	passwd, err := scanner.GetPassword(passMsg, true)
+	if err != nil {
+		panic(err)
+	}
+
+	buffer, err := passwd.Open()
+	if err != nil {
+		panic(err)
+	}
+
+	defer buffer.Destroy()
+
+	return buffer.String()
+}
+
+// newHTTPClient used for the IAM and STS requests.
+func newHTTPClient(tlsConfig TLSConfig, networkConfig NetworkConfig) (*http.Client, error) {
+	cfg, err := tlsConfig.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	tr := networkConfig.Transport()
+	tr.TLSClientConfig = cfg
+
+	return &http.Client{ // nolint:exhaustivestruct
+		Transport: tr,
+	}, nil
+}
+
 func getBaseLogDir() (baseLogDir string) {
 	baseLogDir, errConfDir := os.UserConfigDir()
 	if errConfDir != nil {
@@ -613,15 +1074,18 @@ func getBaseLogDir() (baseLogDir string) {
 
 // init of the cobra root command and viper configuration.
 func init() { //nolint: gochecknoinits
-	cobra.OnInitialize(initConfig)
-
 	defaultLogFile = filepath.Join(getBaseLogDir(), "log", "sts-wire.log")
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "./config.json", "config file")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "",
+		"config file (default config.{yml,yaml,json} in ~/.sts-wire or in the current folder)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "",
+		"profile with the IAM server, the S3 endpoint and the other settings of a site (see config profiles)")
+	rootCmd.PersistentFlags().StringVar(&profilesFile, "profilesFile", "",
+		"file with the user profiles (default ~/.sts-wire/profiles.yml)")
 	rootCmd.PersistentFlags().StringVar(&logFile, "log", defaultLogFile,
 		"where the log has to write, a file path or stderr")
 	rootCmd.PersistentFlags().StringVar(&rcloneMountFlags, "rcloneMountFlags", rcloneMountFlags,
-		"overwrite the rclone mount flags")
+		"rclone mount flags merged with the default ones, e.g. \"--dir-cache-time 1m --fuse-flag allow_other\"")
 	rootCmd.PersistentFlags().BoolVar(&debug, "debug", false, "start the program in debug mode")
 	rootCmd.PersistentFlags().BoolVar(&insecureConn, "insecureConn", false, "check the http connection certificate")
 	rootCmd.PersistentFlags().IntVar(&refreshTokenRenew, "refreshTokenRenew", 15,
@@ -631,32 +1095,28 @@ func init() { //nolint: gochecknoinits
 	rootCmd.PersistentFlags().BoolVar(&noDummyFileCheck, "noDummyFileCheck", false, "disable dummy file check on mountpoint")
 	rootCmd.PersistentFlags().StringVar(&localCache, "localCache", "off", "choose local cache type [off,minimal,writes,full]")
 	rootCmd.PersistentFlags().StringVar(&localCacheDir, "localCacheDir", "./.rcloneMountCache", "path for the local cache directory, used if localCache is different from \"off\"")
+	rootCmd.PersistentFlags().StringVar(&cacheMaxSize, "cacheMaxSize", "", "max total size of the local cache, e.g. 20G or off (default 10G with localCache full)")
+	rootCmd.PersistentFlags().BoolVar(&keepCache, "keepCache", false, "keep the local cache across restarts and remounts")
+	rootCmd.PersistentFlags().StringVar(&bwLimit, "bwlimit", "", "bandwidth limit, e.g. 10M, upload:download 10M:off or a timetable \"08:00,10M 20:00,off\"")
 	rootCmd.PersistentFlags().BoolVar(&readOnly, "readOnly", false, "mount with read-only option")
 	rootCmd.PersistentFlags().BoolVar(&tryRemount, "tryRemount", true,
 		"try to remount if there are any rclone errors (up to 10 times)")
-
-	errFlag := viper.BindPFlag("insecureConn", rootCmd.PersistentFlags().Lookup("insecureConn"))
-	if errFlag != nil {
-		panic(errFlag)
-	}
-
-	errFlag = viper.BindPFlag("refreshTokenRenew", rootCmd.PersistentFlags().Lookup("refreshTokenRenew"))
-	if errFlag != nil {
-		panic(errFlag)
-	}
-
-	errFlag = viper.BindPFlag("log", rootCmd.PersistentFlags().Lookup("log"))
-	if errFlag != nil {
-		panic(errFlag)
-	}
-
-	errFlag = viper.BindPFlag("rcloneMountFlags", rootCmd.PersistentFlags().Lookup("rcloneMountFlags"))
-	if errFlag != nil {
-		panic(errFlag)
-	}
+	rootCmd.PersistentFlags().BoolVar(&allowNonEmpty, "allowNonEmpty", false, "mount over the files of a non-empty local mount point")
+	rootCmd.PersistentFlags().BoolVar(&unmountStale, "unmountStale", false,
+		"unmount without asking the local mount point left by a previous run of the instance")
+	rootCmd.PersistentFlags().BoolVar(&noTokenVerify, "noTokenVerify", false,
+		"do not verify the signature and the claims of the access token")
+	rootCmd.PersistentFlags().StringVar(&caFile, "caFile", "", "PEM file with the CA certificates to trust besides the system ones")
+	rootCmd.PersistentFlags().StringVar(&caDir, "caDir", "", "directory with the PEM CA certificates (.pem, .crt, .cer) to trust")
+	rootCmd.PersistentFlags().StringVar(&clientCert, "clientCert", "", "PEM client certificate for mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&clientKey, "clientKey", "", "PEM private key of the client certificate")
+	rootCmd.PersistentFlags().StringVar(&proxy, "proxy", "", "HTTP proxy URL for the IAM, STS and S3 connections (default from HTTP_PROXY/HTTPS_PROXY)")
+	rootCmd.PersistentFlags().StringVar(&noProxy, "noProxy", "", "comma separated hosts and domains reached without the proxy")
+	rootCmd.PersistentFlags().StringVar(&ipVersion, "ipVersion", "", "force the IP version of the connections [4,6]")
+	rootCmd.PersistentFlags().BoolVar(&unsafeLogSecrets, "unsafeLogSecrets", false,
+		"write tokens, secrets and keys in clear in the logs and in the reports (only for debugging)")
 
 	viper.SetConfigName("config")
-	viper.SetConfigType("yml")
 
 	home, err := homedir.Dir()
 	if err != nil {
@@ -669,22 +1129,21 @@ func init() { //nolint: gochecknoinits
 	rootCmd.AddCommand(versionCmd)
 	rootCmd.AddCommand(cleanCmd)
 	rootCmd.AddCommand(reportCmd)
-}
-
-// initConfig of viper.
-func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		if _, err := os.Stat(cfgFile); err == nil || os.IsExist(err) {
-			viper.SetConfigFile(cfgFile)
-		} else {
-			cfgFile = ""
-		}
-	}
-
-	viper.AutomaticEnv()
-
-	if err := viper.ReadInConfig(); err == nil {
-		fmt.Printf("==> sts-wire is using config file: %s\n", viper.ConfigFileUsed())
-	}
+	rootCmd.AddCommand(whoamiCmd)
+	rootCmd.AddCommand(reloadCmd)
+	rootCmd.AddCommand(bwLimitCmd)
+	rootCmd.AddCommand(statsCmd)
+	rootCmd.AddCommand(umountCmd)
+	refreshDirCmd.Flags().BoolVarP(&refreshRecursive, "recursive", "r", false, "refresh also the subdirectories")
+	rootCmd.AddCommand(refreshDirCmd)
+
+	initCmd.Flags().BoolVar(&configForce, "force", false, "overwrite the config file if it exists")
+	configInitCmd.Flags().BoolVar(&configForce, "force", false, "overwrite the config file if it exists")
+	configShowCmd.Flags().BoolVar(&configSources, "sources", false, "print where each value comes from")
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configProfilesCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(initCmd)
 }
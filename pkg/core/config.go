@@ -0,0 +1,750 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/DODAS-TS/sts-wire/pkg/rclone"
+	"github.com/DODAS-TS/sts-wire/pkg/redact"
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	envPrefix       = "STS_WIRE_"
+	sourceDefault   = "default"
+	sourceFile      = "file"
+	sourceArgument  = "argument"
+	sourceProfile   = "profile"
+	defaultLocalDir = "./.rcloneMountCache"
+	// defaultConfigFile written by config init.
+	defaultConfigFile = "config.yml"
+	configFileMode    = 0600
+	// minHealthCheckInterval avoids to stress the mount point.
+	minHealthCheckInterval = 10 * time.Second
+)
+
+var (
+	errConfigNotFound    = errors.New("config file not found")
+	errConfigExists      = errors.New("config file already exists")
+	errMissingConfigKey  = errors.New("required")
+	errNoValidConfigType = errors.New("no valid value")
+	// positionalKeys are the keys of the positional arguments, in order.
+	positionalKeys = []string{ // nolint:gochecknoglobals
+		"iamServer", "instanceName", "s3Endpoint", "rcloneRemotePath", "localMountPoint",
+	}
+	// shortPositionalKeys are the keys of the positional arguments when the
+	// other values come from a profile or from the config file.
+	shortPositionalKeys = []string{"rcloneRemotePath", "localMountPoint"} // nolint:gochecknoglobals
+	// currentConfig is the effective configuration of the running instance.
+	currentConfig Config // nolint:gochecknoglobals
+)
+
+// ProxyOptions of the outbound connections.
+type ProxyOptions struct {
+	HTTP    string `mapstructure:"http" yaml:"http" flag:"proxy" desc:"HTTP proxy URL, empty to use HTTP_PROXY"`
+	HTTPS   string `mapstructure:"https" yaml:"https" flag:"proxy" desc:"HTTPS proxy URL, empty to use HTTPS_PROXY"`
+	NoProxy string `mapstructure:"noProxy" yaml:"noProxy" flag:"noProxy" desc:"comma separated hosts and domains reached without the proxy"`
+}
+
+// NetworkOptions of the outbound connections.
+type NetworkOptions struct {
+	ConnectTimeout      time.Duration `mapstructure:"connectTimeout" yaml:"connectTimeout" desc:"timeout of the TCP connection, 0 for 10s"`
+	TLSHandshakeTimeout time.Duration `mapstructure:"tlsHandshakeTimeout" yaml:"tlsHandshakeTimeout" desc:"timeout of the TLS handshake, 0 for 10s"`
+	ResponseTimeout     time.Duration `mapstructure:"responseTimeout" yaml:"responseTimeout" desc:"timeout of the response headers, 0 for 30s"`
+	IPVersion           string        `mapstructure:"ipVersion" yaml:"ipVersion" flag:"ipVersion" desc:"force the IP version [4,6], empty for both"`
+}
+
+// Config of sts-wire. Each key is read, in order of precedence, from the
+// command line flags (and the positional arguments), from the STS_WIRE_<KEY>
+// environment variables, from the config file, from the selected profile and
+// from the defaults.
+type Config struct {
+	Profile string `mapstructure:"profile" yaml:"profile" flag:"profile" desc:"profile with the settings of a site, e.g. infncloud"`
+
+	IAMServer        string `mapstructure:"iamServer" yaml:"iamServer" legacy:"IAM_Server" legacyEnv:"IAM_SERVER" desc:"URL of the IAM server"`
+	InstanceName     string `mapstructure:"instanceName" yaml:"instanceName" legacy:"instance_name" desc:"name of the instance, used for the rclone remote and the .<instance> folder"`
+	S3Endpoint       string `mapstructure:"s3Endpoint" yaml:"s3Endpoint" legacy:"s3_endpoint" desc:"URL of the S3 endpoint and of its STS"`
+	RcloneRemotePath string `mapstructure:"rcloneRemotePath" yaml:"rcloneRemotePath" legacy:"rclone_remote_path" desc:"bucket path to mount, e.g. /bucket/folder"`
+	LocalMountPoint  string `mapstructure:"localMountPoint" yaml:"localMountPoint" legacy:"local_mount_point" desc:"local folder where the bucket is mounted"`
+
+	Log                 string           `mapstructure:"log" yaml:"log" flag:"log" desc:"log file (*.log) or stderr"`
+	LogLevel            string           `mapstructure:"logLevel" yaml:"logLevel" desc:"log level [trace,debug,info,warn,error]"`
+	NoPassword          bool             `mapstructure:"noPassword" yaml:"noPassword" flag:"noPassword" desc:"do not encrypt the IAM client credentials with a password"`
+	RefreshTokenRenew   int              `mapstructure:"refreshTokenRenew" yaml:"refreshTokenRenew" flag:"refreshTokenRenew" desc:"minutes between two token renewals, min 15"`
+	ReadOnly            bool             `mapstructure:"readOnly" yaml:"readOnly" flag:"readOnly" desc:"mount the bucket read-only"`
+	NoModtime           bool             `mapstructure:"noModtime" yaml:"noModtime" flag:"noModtime" desc:"do not read and write the modification time"`
+	NoDummyFileCheck    bool             `mapstructure:"noDummyFileCheck" yaml:"noDummyFileCheck" flag:"noDummyFileCheck" desc:"do not check the mount point with a dummy file"`
+	LocalCache          string           `mapstructure:"localCache" yaml:"localCache" flag:"localCache" desc:"rclone VFS cache mode [off,minimal,writes,full]"`
+	LocalCacheDir       string           `mapstructure:"localCacheDir" yaml:"localCacheDir" flag:"localCacheDir" desc:"folder of the local cache, used if localCache is not off"`
+	Cache               CacheOptions     `mapstructure:"cache" yaml:"cache" desc:"settings of the local cache"`
+	Bandwidth           BandwidthOptions `mapstructure:"bandwidth" yaml:"bandwidth" desc:"bandwidth limits of the transfers"`
+	Refresh             RefreshOptions   `mapstructure:"refresh" yaml:"refresh" desc:"periodic refresh of the directory cache"`
+	RcloneMountFlags    string           `mapstructure:"rcloneMountFlags" yaml:"rcloneMountFlags" flag:"rcloneMountFlags" desc:"rclone mount flags merged with the default ones, e.g. --dir-cache-time 1m --fuse-flag allow_other"`
+	RcloneProvider      string           `mapstructure:"rcloneProvider" yaml:"rcloneProvider" desc:"S3 provider of the rclone remote, empty for INFN Cloud with a token or Minio with static keys"`
+	TryRemount          bool             `mapstructure:"tryRemount" yaml:"tryRemount" flag:"tryRemount" desc:"remount if rclone fails (up to 10 times)"`
+	AllowNonEmpty       bool             `mapstructure:"allowNonEmpty" yaml:"allowNonEmpty" flag:"allowNonEmpty" desc:"mount over the files of a non-empty mount point, hiding them"`
+	UnmountStale        bool             `mapstructure:"unmountStale" yaml:"unmountStale" flag:"unmountStale" desc:"unmount without asking the mount point left by a previous run of the instance"`
+	HealthCheckInterval time.Duration    `mapstructure:"healthCheckInterval" yaml:"healthCheckInterval" desc:"time between two checks of the mount point"`
+	ShutdownTimeout     time.Duration    `mapstructure:"shutdownTimeout" yaml:"shutdownTimeout" desc:"max time to wait at exit for the uploads of the local cache, 0 to not wait"`
+	NoTokenVerify       bool             `mapstructure:"noTokenVerify" yaml:"noTokenVerify" flag:"noTokenVerify" desc:"do not verify the signature and the claims of the access token"`
+
+	IAMAuthURL      string            `mapstructure:"iamAuthURL" yaml:"iamAuthURL" desc:"host of the local callback server of the login"`
+	IAMAuthURLPort  int               `mapstructure:"iamAuthURLPort" yaml:"iamAuthURLPort" desc:"port of the local callback server, 0 for a random one"`
+	IAMClientID     string            `mapstructure:"iamClientID" yaml:"iamClientID" desc:"ID of a pre-registered IAM client, empty for the dynamic registration"`
+	IAMClientSecret string            `mapstructure:"iamClientSecret" yaml:"iamClientSecret" desc:"secret of the pre-registered IAM client, empty for a public client"`
+	IAMRedirectURI  string            `mapstructure:"iamRedirectURI" yaml:"iamRedirectURI" desc:"redirect URI of the pre-registered IAM client"`
+	IAMScopes       []string          `mapstructure:"iamScopes" yaml:"iamScopes" desc:"scopes of the login, empty for the default ones"`
+	IAMAudience     []string          `mapstructure:"iamAudience" yaml:"iamAudience" desc:"audience of the access token"`
+	IAMAuthParams   map[string]string `mapstructure:"iamAuthParams" yaml:"iamAuthParams" desc:"extra parameters of the authorization request"`
+
+	TokenExchange TokenExchangeConfig `mapstructure:"tokenExchange" yaml:"tokenExchange" desc:"exchange of the access token before the STS call"`
+
+	STSAction          string `mapstructure:"stsAction" yaml:"stsAction" desc:"STS action [AssumeRoleWithWebIdentity,AssumeRoleWithClientGrants,AssumeRoleWithLDAPIdentity,AssumeRole]"`
+	LDAPUsername       string `mapstructure:"ldapUsername" yaml:"ldapUsername" desc:"LDAP user of AssumeRoleWithLDAPIdentity"`
+	STSAccessKey       string `mapstructure:"stsAccessKey" yaml:"stsAccessKey" desc:"access key of AssumeRole"`
+	STSRoleArn         string `mapstructure:"stsRoleArn" yaml:"stsRoleArn" desc:"ARN of the role to assume"`
+	STSRoleSessionName string `mapstructure:"stsRoleSessionName" yaml:"stsRoleSessionName" desc:"name of the role session"`
+	STSPolicyFile      string `mapstructure:"stsPolicyFile" yaml:"stsPolicyFile" desc:"JSON session policy that restricts the credentials"`
+
+	InsecureConn bool   `mapstructure:"insecureConn" yaml:"insecureConn" flag:"insecureConn" desc:"do not check the server certificates"`
+	CAFile       string `mapstructure:"caFile" yaml:"caFile" flag:"caFile" desc:"PEM file with the CA certificates to trust besides the system ones"`
+	CADir        string `mapstructure:"caDir" yaml:"caDir" flag:"caDir" desc:"folder with the PEM CA certificates (.pem, .crt, .cer) to trust"`
+	ClientCert   string `mapstructure:"clientCert" yaml:"clientCert" flag:"clientCert" desc:"PEM client certificate for mutual TLS"`
+	ClientKey    string `mapstructure:"clientKey" yaml:"clientKey" flag:"clientKey" desc:"PEM private key of the client certificate"`
+
+	Proxy   ProxyOptions   `mapstructure:"proxy" yaml:"proxy" desc:"proxy of the IAM, STS and S3 connections"`
+	Network NetworkOptions `mapstructure:"network" yaml:"network" desc:"timeouts and IP version of the connections"`
+
+	// sources of the values, by key
+	sources map[string]string
+	// unknown keys of the config file
+	unknown []string
+}
+
+// DefaultConfig returns the configuration with the default values.
+func DefaultConfig() Config {
+	return Config{ // nolint:exhaustivestruct
+		Log:                 defaultLogFile,
+		LogLevel:            "debug",
+		HealthCheckInterval: checkRuntimeRcloneSleep,
+		ShutdownTimeout:     defaultShutdownTimeout,
+		RefreshTokenRenew:   15, // nolint:gomnd
+		LocalCache:          "off",
+		LocalCacheDir:       defaultLocalDir,
+		TryRemount:          true,
+		IAMAuthURL:          "localhost",
+		STSAction:           STSActionWebIdentity,
+	}
+}
+
+// configField is a leaf of the configuration.
+type configField struct {
+	key   string
+	field reflect.StructField
+	value reflect.Value
+}
+
+// fields returns the leaves of the configuration, sections first flattened
+// with a dotted key, e.g. tokenExchange.enabled.
+func (c *Config) fields() []configField {
+	return walkFields(reflect.ValueOf(c).Elem(), "")
+}
+
+func walkFields(value reflect.Value, prefix string) []configField {
+	fields := []configField{}
+
+	for idx := 0; idx < value.NumField(); idx++ {
+		field := value.Type().Field(idx)
+
+		key := field.Tag.Get("mapstructure")
+		if key == "" {
+			continue
+		}
+
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			fields = append(fields, walkFields(value.Field(idx), key)...)
+
+			continue
+		}
+
+		fields = append(fields, configField{key: key, field: field, value: value.Field(idx)})
+	}
+
+	return fields
+}
+
+// envName returns the environment variable of a key: STS_WIRE_ followed by
+// the key in upper snake case, e.g. tokenExchange.enabled ->
+// STS_WIRE_TOKEN_EXCHANGE_ENABLED.
+func envName(key string) string {
+	var name strings.Builder
+
+	name.WriteString(envPrefix)
+
+	runes := []rune(key)
+	for idx, char := range runes {
+		switch {
+		case char == '.':
+			name.WriteRune('_')
+
+			continue
+		case idx > 0 && unicode.IsUpper(char) && runes[idx-1] != '.':
+			prev := runes[idx-1]
+			nextLower := idx+1 < len(runes) && unicode.IsLower(runes[idx+1])
+
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				name.WriteRune('_')
+			}
+		}
+
+		name.WriteRune(unicode.ToUpper(char))
+	}
+
+	return name.String()
+}
+
+// setString parses a value of the environment or of a flag.
+func setString(value reflect.Value, raw string) error {
+	switch value.Interface().(type) {
+	case string:
+		value.SetString(raw)
+	case bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%w '%s': %v", errNoValidConfigType, raw, err)
+		}
+
+		value.SetBool(parsed)
+	case int:
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%w '%s': %v", errNoValidConfigType, raw, err)
+		}
+
+		value.SetInt(int64(parsed))
+	case time.Duration:
+		parsed, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%w '%s': %v", errNoValidConfigType, raw, err)
+		}
+
+		value.SetInt(int64(parsed))
+	case []string:
+		list := []string{}
+
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+
+		value.Set(reflect.ValueOf(list))
+	case map[string]string:
+		params := map[string]string{}
+
+		for _, item := range strings.Split(raw, ",") {
+			parts := strings.SplitN(strings.TrimSpace(item), "=", 2) // nolint:gomnd
+			if len(parts) != 2 || parts[0] == "" {                   // nolint:gomnd
+				return fmt.Errorf("%w '%s', use key=value,key=value", errNoValidConfigType, raw)
+			}
+
+			params[parts[0]] = parts[1]
+		}
+
+		value.Set(reflect.ValueOf(params))
+	}
+
+	return nil
+}
+
+// LoadConfig returns the configuration merged from the defaults, the profile,
+// the config file, the environment, the flags and the positional arguments.
+func LoadConfig(flags *pflag.FlagSet, args []string) (Config, error) {
+	cfg := DefaultConfig()
+	cfg.sources = map[string]string{}
+
+	settings, err := readConfigFile()
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.loadProfile(flags, settings); err != nil {
+		return cfg, err
+	}
+
+	if settings != nil {
+		unknown, errDecode := cfg.decode(settings, sourceFile)
+		if errDecode != nil {
+			return cfg, fmt.Errorf("config file %s: %w", viper.ConfigFileUsed(), errDecode)
+		}
+
+		cfg.unknown = unknown
+	}
+
+	var errs validator.Errors
+
+	for _, field := range cfg.fields() {
+		if _, found := cfg.sources[field.key]; !found {
+			cfg.sources[field.key] = sourceDefault
+		}
+
+		for _, env := range []string{envName(field.key), field.field.Tag.Get("legacyEnv")} {
+			if raw, found := os.LookupEnv(env); env != "" && found && raw != "" {
+				errs.Add(env, setString(field.value, raw))
+				cfg.sources[field.key] = "env " + env
+
+				break
+			}
+		}
+
+		if flagName := field.field.Tag.Get("flag"); flagName != "" && flags != nil && flags.Changed(flagName) {
+			errs.Add("--"+flagName, setString(field.value, flags.Lookup(flagName).Value.String()))
+			cfg.sources[field.key] = "flag --" + flagName
+		}
+	}
+
+	cfg.setArgs(args)
+
+	if cfg.InstanceName == "" && cfg.Profile != "" {
+		cfg.InstanceName = cfg.Profile
+		cfg.sources["instanceName"] = sourceProfile + " " + cfg.Profile
+	}
+
+	return cfg, errs.Err()
+}
+
+// setArgs sets the positional arguments: all the five values or, with the
+// other ones taken from a profile or from the config file, only the remote
+// path and the local mount point.
+func (c *Config) setArgs(args []string) {
+	keys := positionalKeys
+
+	if len(args) == len(shortPositionalKeys) {
+		keys = shortPositionalKeys
+
+		// the bucket name is enough
+		if !strings.HasPrefix(args[0], "/") {
+			args = append([]string{"/" + args[0]}, args[1:]...)
+		}
+	}
+
+	if len(args) != len(keys) {
+		return
+	}
+
+	for idx, key := range keys {
+		for _, field := range c.fields() {
+			if field.key == key {
+				field.value.SetString(args[idx])
+				c.sources[key] = sourceArgument
+			}
+		}
+	}
+}
+
+// readConfigFile returns the settings of the config file, nil if there is no
+// config file. The legacy keys, e.g. IAM_Server, are renamed to the current
+// ones.
+func readConfigFile() (map[string]interface{}, error) {
+	if cfgFile != "" {
+		if _, err := os.Stat(cfgFile); err != nil {
+			return nil, fmt.Errorf("%w: %s", errConfigNotFound, cfgFile)
+		}
+
+		viper.SetConfigFile(cfgFile)
+	}
+
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if errors.As(err, &notFound) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("config file %s: %w", viper.ConfigFileUsed(), err)
+	}
+
+	return viper.AllSettings(), nil
+}
+
+// decode sets the values found in the settings of a config or profiles file
+// and returns the unknown keys.
+func (c *Config) decode(settings map[string]interface{}, source string) ([]string, error) {
+	known := map[string]bool{}
+	unknown := []string{}
+
+	for _, field := range c.fields() {
+		key := strings.ToLower(field.key)
+		section := strings.SplitN(key, ".", 2)[0] // nolint:gomnd
+		known[section] = true
+
+		if legacy := strings.ToLower(field.field.Tag.Get("legacy")); legacy != "" {
+			if value, found := settings[legacy]; found {
+				if _, current := settings[key]; !current {
+					settings[key] = value
+				}
+
+				delete(settings, legacy)
+			}
+		}
+
+		if isSet(settings, key) {
+			c.sources[field.key] = source
+		}
+	}
+
+	for key := range settings {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+
+	sort.Strings(unknown)
+
+	decoder := viper.New()
+	if err := decoder.MergeConfigMap(settings); err != nil {
+		return unknown, fmt.Errorf("decode %w", err)
+	}
+
+	if err := decoder.Unmarshal(c); err != nil {
+		return unknown, fmt.Errorf("decode %w", err)
+	}
+
+	return unknown, nil
+}
+
+// isSet reports if a dotted key is in the settings of the config file.
+func isSet(settings map[string]interface{}, key string) bool {
+	parts := strings.SplitN(key, ".", 2) // nolint:gomnd
+
+	value, found := settings[parts[0]]
+	if !found || len(parts) == 1 {
+		return found
+	}
+
+	section, isMap := value.(map[string]interface{})
+
+	return isMap && isSet(section, parts[1])
+}
+
+// File returns the config file used, if any.
+func (c Config) File() string {
+	return viper.ConfigFileUsed()
+}
+
+// Unknown returns the keys of the config file that are not used.
+func (c Config) Unknown() []string {
+	return c.unknown
+}
+
+// Source returns where the value of a key comes from.
+func (c Config) Source(key string) string {
+	return c.sources[key]
+}
+
+// Validate checks all the values and returns all the errors found.
+func (c Config) Validate() error { // nolint:funlen,gocognit,cyclop
+	var errs validator.Errors
+
+	if c.STSConfig().NeedsToken() || c.IAMServer != "" {
+		if c.IAMServer == "" {
+			errs.Add("iamServer", errMissingConfigKey)
+		} else {
+			_, err := validator.WebURL(c.IAMServer)
+			errs.Add("iamServer", err)
+		}
+	}
+
+	required := []struct {
+		key   string
+		value string
+		check func(string) (bool, error)
+	}{
+		{"instanceName", c.InstanceName, validator.InstanceName},
+		{"s3Endpoint", c.S3Endpoint, validator.S3Endpoint},
+		{"rcloneRemotePath", c.RcloneRemotePath, validator.RemotePath},
+		{"localMountPoint", c.LocalMountPoint, validator.LocalPath},
+	}
+
+	for _, item := range required {
+		if item.value == "" {
+			errs.Add(item.key, errMissingConfigKey)
+
+			continue
+		}
+
+		_, err := item.check(item.value)
+		errs.Add(item.key, err)
+	}
+
+	if c.Log != "stderr" {
+		_, err := validator.LogFile(c.Log)
+		errs.Add("log", err)
+	}
+
+	_, err := validator.RefreshTokenRenew(c.RefreshTokenRenew)
+	errs.Add("refreshTokenRenew", err)
+
+	_, err = validator.LogLevel(c.LogLevel)
+	errs.Add("logLevel", err)
+
+	if c.HealthCheckInterval < minHealthCheckInterval {
+		errs.Add("healthCheckInterval", fmt.Errorf("%w: min %s", errNoValidConfigType, minHealthCheckInterval))
+	}
+
+	if c.ShutdownTimeout < 0 {
+		errs.Add("shutdownTimeout", errNoValidCacheDuration)
+	}
+
+	_, err = validator.LocalCache(c.LocalCache)
+	errs.Add("localCache", err)
+
+	if !strings.EqualFold(c.LocalCache, "off") {
+		_, err = validator.LocalPath(c.LocalCacheDir)
+		errs.Add("localCacheDir", err)
+	}
+
+	_, err = rclone.ParseMountOptions(c.RcloneMountFlags)
+	errs.Add("rcloneMountFlags", err)
+
+	_, err = validator.WebURL(c.IAMAuthURL)
+	errs.Add("iamAuthURL", err)
+
+	_, err = validator.Port(c.IAMAuthURLPort)
+	errs.Add("iamAuthURLPort", err)
+
+	if staticClient := c.StaticClient(); staticClient.Enabled() && staticClient.RedirectURI != "" {
+		if _, err = validator.WebURL(staticClient.RedirectURI); err == nil {
+			_, _, err = staticClient.Callback()
+		}
+
+		errs.Add("iamRedirectURI", err)
+	}
+
+	_, err = validator.STSAction(c.STSAction)
+	errs.Add("stsAction", err)
+
+	switch {
+	case c.STSAction == STSActionLDAPIdentity && c.LDAPUsername == "":
+		errs.Add("ldapUsername", errMissingConfigKey)
+	case c.STSAction == STSActionAssumeRole && c.STSAccessKey == "":
+		errs.Add("stsAccessKey", errMissingConfigKey)
+	}
+
+	if c.STSRoleArn != "" {
+		_, err = validator.RoleArn(c.STSRoleArn)
+		errs.Add("stsRoleArn", err)
+	}
+
+	if c.STSRoleSessionName != "" {
+		_, err = validator.RoleSessionName(c.STSRoleSessionName)
+		errs.Add("stsRoleSessionName", err)
+	}
+
+	_, err = c.STSPolicy()
+	errs.Add("stsPolicyFile", err)
+
+	_, err = c.TLSConfig().Build()
+	errs.Add("tls", err)
+
+	errs.Add("network", c.NetworkConfig().Validate())
+
+	addSectionErrors(&errs, "cache", c.Cache.Validate())
+	addSectionErrors(&errs, "bandwidth", c.Bandwidth.Validate())
+	addSectionErrors(&errs, "refresh", c.Refresh.Validate())
+
+	return errs.Err()
+}
+
+// addSectionErrors adds each error of a section of the configuration.
+func addSectionErrors(errs *validator.Errors, section string, err error) {
+	var sectionErrs validator.Errors
+	if !errors.As(err, &sectionErrs) {
+		errs.Add(section, err)
+
+		return
+	}
+
+	for _, sectionErr := range sectionErrs {
+		errs.Add(section, sectionErr)
+	}
+}
+
+// STSPolicy returns the session policy without spaces, as the size limit of
+// the STS applies to the compact policy.
+func (c Config) STSPolicy() (string, error) {
+	if c.STSPolicyFile == "" {
+		return "", nil
+	}
+
+	policy, err := os.ReadFile(c.STSPolicyFile)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the session policy %w", err)
+	}
+
+	var compactPolicy bytes.Buffer
+	if errCompact := json.Compact(&compactPolicy, policy); errCompact != nil {
+		compactPolicy.Reset()
+		compactPolicy.Write(policy)
+	}
+
+	if valid, errPolicy := validator.SessionPolicy(compactPolicy.String()); !valid || errPolicy != nil {
+		return "", fmt.Errorf("%s %w", c.STSPolicyFile, errPolicy)
+	}
+
+	return compactPolicy.String(), nil
+}
+
+// STSConfig returns the STS options, without the secrets.
+func (c Config) STSConfig() STSConfig {
+	return STSConfig{ // nolint:exhaustivestruct
+		Action:          c.STSAction,
+		LDAPUsername:    c.LDAPUsername,
+		AccessKey:       c.STSAccessKey,
+		RoleArn:         c.STSRoleArn,
+		RoleSessionName: c.STSRoleSessionName,
+	}
+}
+
+// OAuthOptions returns the options of the login.
+func (c Config) OAuthOptions() OAuthOptions {
+	return OAuthOptions{
+		Scopes:      c.IAMScopes,
+		Audience:    c.IAMAudience,
+		ExtraParams: c.IAMAuthParams,
+	}
+}
+
+// StaticClient returns the pre-registered IAM client.
+func (c Config) StaticClient() StaticClientConfig {
+	return StaticClientConfig{
+		ClientID:     c.IAMClientID,
+		ClientSecret: c.IAMClientSecret,
+		RedirectURI:  c.IAMRedirectURI,
+	}
+}
+
+// TLSConfig returns the TLS options.
+func (c Config) TLSConfig() TLSConfig {
+	return TLSConfig{
+		Insecure:   c.InsecureConn,
+		CAFile:     c.CAFile,
+		CADir:      c.CADir,
+		ClientCert: c.ClientCert,
+		ClientKey:  c.ClientKey,
+	}
+}
+
+// NetworkConfig returns the proxy and network options.
+func (c Config) NetworkConfig() NetworkConfig {
+	return NetworkConfig{
+		HTTPProxy:           c.Proxy.HTTP,
+		HTTPSProxy:          c.Proxy.HTTPS,
+		NoProxy:             c.Proxy.NoProxy,
+		ConnectTimeout:      c.Network.ConnectTimeout,
+		TLSHandshakeTimeout: c.Network.TLSHandshakeTimeout,
+		ResponseTimeout:     c.Network.ResponseTimeout,
+		IPVersion:           c.Network.IPVersion,
+	}
+}
+
+// MountOptions returns the rclone mount options set by the user. The
+// configuration has to be valid.
+func (c Config) MountOptions() rclone.MountOptions {
+	options, err := rclone.ParseMountOptions(c.RcloneMountFlags)
+	if err != nil {
+		panic(err)
+	}
+
+	return options
+}
+
+// YAML returns the configuration as a YAML document. With comments each key
+// is preceded by its description; with redacted the secrets are masked.
+func (c Config) YAML(comments bool, redacted bool) ([]byte, error) {
+	var out bytes.Buffer
+
+	if err := writeYAML(&out, reflect.ValueOf(c), 0, comments, redacted); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+func writeYAML(out *bytes.Buffer, value reflect.Value, depth int, comments bool, redacted bool) error {
+	indent := strings.Repeat("  ", depth)
+
+	for idx := 0; idx < value.NumField(); idx++ {
+		field := value.Type().Field(idx)
+
+		key := field.Tag.Get("yaml")
+		if key == "" {
+			continue
+		}
+
+		if comments {
+			if idx > 0 && depth == 0 {
+				out.WriteRune('\n')
+			}
+
+			fmt.Fprintf(out, "%s# %s\n", indent, field.Tag.Get("desc"))
+		}
+
+		if field.Type.Kind() == reflect.Struct {
+			fmt.Fprintf(out, "%s%s:\n", indent, key)
+
+			if err := writeYAML(out, value.Field(idx), depth+1, comments, redacted); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		var leaf interface{} = value.Field(idx).Interface()
+
+		switch typed := leaf.(type) {
+		case time.Duration:
+			leaf = typed.String()
+		case []string:
+			if typed == nil {
+				leaf = []string{}
+			}
+		case map[string]string:
+			if typed == nil {
+				leaf = map[string]string{}
+			}
+		}
+
+		if redacted {
+			leaf = redact.Value(key, leaf)
+		}
+
+		document, err := yaml.Marshal(map[string]interface{}{key: leaf})
+		if err != nil {
+			return fmt.Errorf("config %s %w", key, err)
+		}
+
+		for _, line := range strings.SplitAfter(string(document), "\n") {
+			if line != "" {
+				out.WriteString(indent + line)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,316 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// setEnv sets an environment variable for the test, empty to unset it.
+func setEnv(t *testing.T, key string, value string) {
+	t.Helper()
+
+	previous, found := os.LookupEnv(key)
+
+	t.Cleanup(func() {
+		if found {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+}
+
+// loadTestConfig loads the configuration with the config and profiles files
+// written in a temporary folder, the flags and the positional arguments.
+func loadTestConfig(t *testing.T, config string, profiles string, flagArgs []string, args []string) (Config, error) {
+	t.Helper()
+
+	tmpDir := t.TempDir()
+
+	for name, content := range map[string]string{"config.yml": config, "profiles.yml": profiles} {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(content), configFileMode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	previousConfig, previousProfiles := cfgFile, profilesFile
+	cfgFile, profilesFile = filepath.Join(tmpDir, "config.yml"), filepath.Join(tmpDir, "profiles.yml")
+
+	t.Cleanup(func() {
+		cfgFile, profilesFile = previousConfig, previousProfiles
+
+		viper.Reset()
+	})
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.String("profile", "", "")
+	flags.String("rcloneMountFlags", "", "")
+
+	if err := flags.Parse(flagArgs); err != nil {
+		t.Fatal(err)
+	}
+
+	return LoadConfig(flags, args)
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	const profiles = "site:\n  rcloneMountFlags: from-profile\n"
+
+	tests := []struct {
+		name     string
+		config   string
+		env      string
+		flags    []string
+		expected string
+		source   string
+	}{
+		{"default", "", "", nil, "", sourceDefault},
+		{"profile", "profile: site\n", "", nil, "from-profile", "profile site"},
+		{"profile flag", "", "", []string{"--profile", "site"}, "from-profile", "profile site"},
+		{"file", "profile: site\nrcloneMountFlags: from-file\n", "", nil, "from-file", sourceFile},
+		{"env", "profile: site\nrcloneMountFlags: from-file\n", "from-env", nil, "from-env",
+			"env STS_WIRE_RCLONE_MOUNT_FLAGS"},
+		{"flag", "profile: site\nrcloneMountFlags: from-file\n", "from-env", []string{"--rcloneMountFlags", "from-flag"},
+			"from-flag", "flag --rcloneMountFlags"},
+	}
+
+	for _, test := range tests {
+		setEnv(t, "STS_WIRE_RCLONE_MOUNT_FLAGS", test.env)
+
+		cfg, err := loadTestConfig(t, test.config, profiles, test.flags, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if cfg.RcloneMountFlags != test.expected || cfg.Source("rcloneMountFlags") != test.source {
+			t.Fatalf("%s: value %q from %q != %q from %q", test.name, cfg.RcloneMountFlags,
+				cfg.Source("rcloneMountFlags"), test.expected, test.source)
+		}
+	}
+}
+
+func TestLoadConfigLegacy(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    string
+		env       string
+		legacyEnv string
+		expected  string
+		source    string
+	}{
+		{"legacy key", "IAM_Server: https://legacy.example.org\n", "", "", "https://legacy.example.org", sourceFile},
+		{"current key first", "IAM_Server: https://legacy.example.org\niamServer: https://iam.example.org\n",
+			"", "", "https://iam.example.org", sourceFile},
+		{"legacy env", "IAM_Server: https://legacy.example.org\n", "", "https://env.example.org",
+			"https://env.example.org", "env IAM_SERVER"},
+		{"current env first", "", "https://iam.example.org", "https://env.example.org",
+			"https://iam.example.org", "env STS_WIRE_IAM_SERVER"},
+	}
+
+	for _, test := range tests {
+		setEnv(t, "STS_WIRE_IAM_SERVER", test.env)
+		setEnv(t, "IAM_SERVER", test.legacyEnv)
+
+		cfg, err := loadTestConfig(t, test.config, "", nil, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		if cfg.IAMServer != test.expected || cfg.Source("iamServer") != test.source {
+			t.Fatalf("%s: value %q from %q != %q from %q", test.name, cfg.IAMServer, cfg.Source("iamServer"),
+				test.expected, test.source)
+		}
+
+		if len(cfg.Unknown()) != 0 {
+			t.Fatalf("%s: unknown keys %v", test.name, cfg.Unknown())
+		}
+	}
+}
+
+func TestLoadConfigArgs(t *testing.T) {
+	const config = "instanceName: file-instance\nrcloneRemotePath: /file-bucket\nlocalMountPoint: /mnt/file\n"
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected [5]string
+		source   string
+	}{
+		{"no arguments", nil, [5]string{"", "file-instance", "", "/file-bucket", "/mnt/file"}, sourceFile},
+		{"bucket name", []string{"bucket", "/mnt/bucket"},
+			[5]string{"", "file-instance", "", "/bucket", "/mnt/bucket"}, sourceArgument},
+		{"bucket path", []string{"/bucket/folder", "/mnt/bucket"},
+			[5]string{"", "file-instance", "", "/bucket/folder", "/mnt/bucket"}, sourceArgument},
+		{"all arguments", []string{"https://iam.example.org", "instance", "https://s3.example.org", "/bucket", "/mnt/bucket"},
+			[5]string{"https://iam.example.org", "instance", "https://s3.example.org", "/bucket", "/mnt/bucket"},
+			sourceArgument},
+		{"wrong number", []string{"instance", "bucket", "/mnt/bucket"},
+			[5]string{"", "file-instance", "", "/file-bucket", "/mnt/file"}, sourceFile},
+	}
+
+	for _, test := range tests {
+		cfg, err := loadTestConfig(t, config, "", nil, test.args)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		values := [5]string{cfg.IAMServer, cfg.InstanceName, cfg.S3Endpoint, cfg.RcloneRemotePath, cfg.LocalMountPoint}
+		if values != test.expected || cfg.Source("localMountPoint") != test.source {
+			t.Fatalf("%s: values %v from %q != %v from %q", test.name, values, cfg.Source("localMountPoint"),
+				test.expected, test.source)
+		}
+	}
+}
+
+func TestLoadConfigUnknown(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		profiles string
+		unknown  []string
+		err      error
+	}{
+		{"known keys", "logLevel: info\ncache:\n  maxSize: 1G\n", "", []string{}, nil},
+		{"unknown keys", "logLevel: info\nlogLevle: info\nIAM_Srever: x\n", "", []string{"iam_srever", "loglevle"}, nil},
+		{"unknown profile keys", "profile: site\n", "site:\n  iamSrever: x\n", []string{}, errUnknownProfileKey},
+		{"unknown profile", "profile: other\n", "site:\n  iamServer: x\n", []string{}, errUnknownProfile},
+	}
+
+	for _, test := range tests {
+		cfg, err := loadTestConfig(t, test.config, test.profiles, nil, nil)
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s: error %v != %v", test.name, err, test.err)
+		}
+
+		if err == nil && !reflect.DeepEqual(cfg.Unknown(), test.unknown) {
+			t.Fatalf("%s: unknown %v != %v", test.name, cfg.Unknown(), test.unknown)
+		}
+	}
+}
+
+// reloadTestConfig returns a valid configuration for the reload tests.
+func reloadTestConfig(t *testing.T) Config {
+	t.Helper()
+
+	cfg := DefaultConfig()
+	cfg.IAMServer = "https://iam.example.org"
+	cfg.InstanceName = "instance"
+	cfg.S3Endpoint = "https://s3.example.org"
+	cfg.RcloneRemotePath = "/bucket"
+	cfg.LocalMountPoint = t.TempDir()
+	cfg.Log = "stderr"
+
+	return cfg
+}
+
+func TestReload(t *testing.T) {
+	level := zerolog.GlobalLevel()
+	t.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+
+	tests := []struct {
+		name            string
+		change          func(cfg *Config)
+		applied         []string
+		restartRequired []string
+		err             bool
+	}{
+		{"no changes", func(cfg *Config) {}, []string{}, []string{}, false},
+		{"live keys", func(cfg *Config) {
+			cfg.RefreshTokenRenew = 30
+			cfg.HealthCheckInterval = time.Minute * 2
+			cfg.Refresh.Dirs = []string{"data"}
+		}, []string{"refreshTokenRenew", "refresh.dirs", "healthCheckInterval"}, []string{}, false},
+		{"restart required", func(cfg *Config) {
+			cfg.IAMServer = "https://other.example.org"
+			cfg.ShutdownTimeout = time.Minute
+		}, []string{"shutdownTimeout"}, []string{"iamServer"}, false},
+		{"empty lists", func(cfg *Config) { cfg.IAMScopes = []string{} }, []string{}, []string{}, false},
+		{"invalid", func(cfg *Config) { cfg.RefreshTokenRenew = 1 }, []string{}, []string{}, true},
+	}
+
+	for _, test := range tests {
+		current := reloadTestConfig(t)
+		server := &Server{config: current} // nolint:exhaustivestruct
+
+		server.reloadConfig = func() (Config, error) {
+			cfg := current
+			test.change(&cfg)
+
+			return cfg, nil
+		}
+
+		result, err := server.reload("test")
+		if (err != nil) != test.err {
+			t.Fatalf("%s: error %v", test.name, err)
+		}
+
+		if !reflect.DeepEqual(result.Applied, test.applied) ||
+			!reflect.DeepEqual(result.RestartRequired, test.restartRequired) || result.Remounted {
+			t.Fatalf("%s: applied %v, restart required %v, remounted %t != %v, %v", test.name, result.Applied,
+				result.RestartRequired, result.Remounted, test.applied, test.restartRequired)
+		}
+
+		if test.err {
+			if server.config.RefreshTokenRenew != current.RefreshTokenRenew {
+				t.Fatalf("%s: invalid configuration applied", test.name)
+			}
+
+			continue
+		}
+
+		// the keys that require a restart keep the running value
+		if server.config.IAMServer != current.IAMServer {
+			t.Fatalf("%s: iamServer %s != %s", test.name, server.config.IAMServer, current.IAMServer)
+		}
+
+		expected := current
+		test.change(&expected)
+
+		if server.RefreshTokenRenew != expected.RefreshTokenRenew ||
+			server.HealthCheckInterval != expected.HealthCheckInterval ||
+			!reflect.DeepEqual(server.Refresh, expected.Refresh) {
+			t.Fatalf("%s: live keys not applied", test.name)
+		}
+	}
+}
+
+func TestReloadFailedRemount(t *testing.T) {
+	current := reloadTestConfig(t)
+	server := &Server{config: current, ReadOnly: current.ReadOnly} // nolint:exhaustivestruct
+	// the rclone config cannot be written in a missing folder
+	server.Client.ConfDir = filepath.Join(t.TempDir(), "missing")
+
+	server.reloadConfig = func() (Config, error) {
+		cfg := current
+		cfg.ReadOnly = !current.ReadOnly
+		cfg.RefreshTokenRenew = 30
+
+		return cfg, nil
+	}
+
+	if _, err := server.reload("test"); err == nil {
+		t.Fatal("reload with a failed remount succeeded")
+	}
+
+	if server.ReadOnly != current.ReadOnly || server.config.ReadOnly != current.ReadOnly {
+		t.Fatalf("readOnly %t, running %t != %t", server.ReadOnly, server.config.ReadOnly, current.ReadOnly)
+	}
+
+	if server.RefreshTokenRenew == 30 || server.config.RefreshTokenRenew != current.RefreshTokenRenew {
+		t.Fatal("live keys applied by a failed reload")
+	}
+}
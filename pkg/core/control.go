@@ -0,0 +1,257 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	controlSocketName  = "control.sock"
+	controlSocketMode  = 0600
+	controlHost        = "sts-wire"
+	controlTimeout     = 5 * time.Minute
+	controlReadTimeout = 10 * time.Second
+)
+
+var errControlAPI = errors.New("control API error")
+
+// reloadResponse is the answer of the token loop to a reload request.
+type reloadResponse struct {
+	result ReloadResult
+	err    error
+}
+
+// controlStatus is the answer of the status endpoint.
+type controlStatus struct {
+	Instance   string `json:"instance"`
+	PID        int    `json:"pid"`
+	MountPoint string `json:"mountPoint"`
+	RemotePath string `json:"remotePath"`
+	ConfigFile string `json:"configFile"`
+}
+
+// bwLimitRequest changes the bandwidth limit.
+type bwLimitRequest struct {
+	Rate string `json:"rate"`
+}
+
+// controlError is the answer of the control API when a request fails.
+type controlError struct {
+	Error string `json:"error"`
+}
+
+// controlSocket returns the path of the control API socket of an instance.
+func controlSocket(confDir string) string {
+	return filepath.Join(confDir, controlSocketName)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Err(err).Msg("control API - response")
+	}
+}
+
+// startControlAPI serves the control API on a unix socket in the instance
+// folder, reachable only by the user. The reloads are executed by the token
+// loop, the other requests use a copy of the state of the server, so a slow
+// rclone does not block the loop.
+func (s *Server) startControlAPI() (*http.Server, error) {
+	socketPath := controlSocket(s.Client.ConfDir)
+
+	// A socket left by a previous run that was not stopped cleanly
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("control API %w", err)
+	}
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("control API %w", err)
+	}
+
+	if err := os.Chmod(socketPath, controlSocketMode); err != nil {
+		listener.Close()
+
+		return nil, fmt.Errorf("control API %w", err)
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		state := s.readState()
+
+		writeJSON(w, http.StatusOK, controlStatus{
+			Instance:   state.instance,
+			PID:        os.Getpid(),
+			MountPoint: state.localPath,
+			RemotePath: state.remotePath,
+			ConfigFile: state.configFile,
+		})
+	})
+
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats, err := s.readState().stats()
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, controlError{Error: err.Error()})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, stats)
+	})
+
+	mux.HandleFunc("/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, controlError{Error: "use POST"})
+
+			return
+		}
+
+		request := make(chan reloadResponse, 1)
+
+		select {
+		case s.reloadChan <- request:
+		case <-r.Context().Done():
+			return
+		}
+
+		response := <-request
+		if response.err != nil {
+			writeJSON(w, http.StatusBadRequest, controlError{Error: response.err.Error()})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, response.result)
+	})
+
+	mux.HandleFunc("/refresh", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJSON(w, http.StatusMethodNotAllowed, controlError{Error: "use POST"})
+
+			return
+		}
+
+		var request refreshRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			writeJSON(w, http.StatusBadRequest, controlError{Error: "send the directory, e.g. {\"dir\": \"data\"}"})
+
+			return
+		}
+
+		result, err := s.readState().refreshDir(request.Dir, request.Recursive)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, controlError{Error: err.Error()})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	})
+
+	mux.HandleFunc("/bwlimit", func(w http.ResponseWriter, r *http.Request) {
+		var request bwLimitRequest
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Rate == "" {
+				writeJSON(w, http.StatusBadRequest, controlError{Error: "send the rate, e.g. {\"rate\": \"10M\"}"})
+
+				return
+			}
+		default:
+			writeJSON(w, http.StatusMethodNotAllowed, controlError{Error: "use GET or POST"})
+
+			return
+		}
+
+		limit, err := s.readState().setBwLimit(request.Rate)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, controlError{Error: err.Error()})
+
+			return
+		}
+
+		writeJSON(w, http.StatusOK, limit)
+	})
+
+	server := &http.Server{ // nolint:exhaustivestruct
+		Handler:           mux,
+		ReadHeaderTimeout: controlReadTimeout,
+	}
+
+	go func() {
+		if errServe := server.Serve(listener); errServe != nil && !errors.Is(errServe, http.ErrServerClosed) {
+			log.Err(errServe).Msg("control API")
+		}
+	}()
+
+	log.Debug().Str("socket", socketPath).Msg("control API - started")
+
+	return server, nil
+}
+
+// controlRequest calls the control API of a running instance, sending the
+// body as JSON, if not nil.
+func controlRequest(confDir string, method string, path string, body interface{}, result interface{}) error {
+	socketPath := controlSocket(confDir)
+
+	client := &http.Client{ // nolint:exhaustivestruct
+		Timeout: controlTimeout,
+		Transport: &http.Transport{ // nolint:exhaustivestruct
+			DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+
+				return dialer.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	var reqBody bytes.Buffer
+
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			return fmt.Errorf("control API %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), method, "http://"+controlHost+path, &reqBody)
+	if err != nil {
+		return fmt.Errorf("control API %w", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("control API of %s, is sts-wire running? %w", confDir, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResponse controlError
+		if errDecode := json.NewDecoder(resp.Body).Decode(&errResponse); errDecode != nil {
+			return fmt.Errorf("%w: %s", errControlAPI, resp.Status)
+		}
+
+		return fmt.Errorf("%w: %s", errControlAPI, errResponse.Error)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("control API response %w", err)
+	}
+
+	return nil
+}
@@ -3,19 +3,31 @@ package core
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/DODAS-TS/sts-wire/pkg/oidc"
+	iamTmpl "github.com/DODAS-TS/sts-wire/pkg/template"
 	"github.com/awnumar/memguard"
 	"github.com/rs/zerolog/log"
 
 	"github.com/gookit/color"
 )
 
+const defaultCallbackPath = "/oauth2/callback"
+
+var (
+	errNoLocalRedirectURI     = errors.New("the redirect URI of a static client has to point to localhost")
+	errNoRedirectURIPort      = errors.New("the redirect URI of a static client needs an explicit port")
+	errNoRegistrationEndpoint = errors.New("the IAM server does not support the dynamic client registration")
+)
+
 type InitClientConfig struct {
 	ConfDir        string
 	ClientConfig   IAMClientConfig
@@ -26,32 +38,94 @@ type InitClientConfig struct {
 	NoPWD          bool
 }
 
-type WellKnown struct {
-	RegisterEndpoint string `json:"registration_endpoint"`
+// StaticClientConfig describes a pre-registered OIDC client taken from the
+// configuration. When ClientID is set the dynamic registration is skipped.
+type StaticClientConfig struct {
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+}
+
+// Enabled reports if a static client is configured.
+func (c StaticClientConfig) Enabled() bool {
+	return c.ClientID != ""
 }
 
-func GetRegisterEndpoint(endpoint string) (register_endpoint string){
-	var c http.Client
-	well_known := endpoint + "/.well-known/openid-configuration"
-	resp, err := c.Get(well_known)
+// Public reports if the static client is a public client (no secret).
+func (c StaticClientConfig) Public() bool {
+	return c.Enabled() && c.ClientSecret == ""
+}
 
-	if err != nil {
-		panic(err)
+// Callback returns the local port and the callback path of the redirect URI.
+func (c StaticClientConfig) Callback() (port int, callbackPath string, err error) {
+	redirectURL, errParse := url.Parse(c.RedirectURI)
+	if errParse != nil {
+		return 0, "", fmt.Errorf("static client redirect URI %w", errParse)
 	}
 
-	defer resp.Body.Close()
+	switch redirectURL.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+	default:
+		return 0, "", fmt.Errorf("%w: %s", errNoLocalRedirectURI, c.RedirectURI)
+	}
 
-	body, err := io.ReadAll(resp.Body)
+	if redirectURL.Port() == "" {
+		return 0, "", fmt.Errorf("%w: %s", errNoRedirectURIPort, c.RedirectURI)
+	}
 
-	var wk WellKnown
+	port, errConv := strconv.Atoi(redirectURL.Port())
+	if errConv != nil {
+		return 0, "", fmt.Errorf("static client redirect URI port %w", errConv)
+	}
 
-	errUnmarshall := json.Unmarshal(body, &wk)
+	callbackPath = redirectURL.Path
+	if callbackPath == "" || callbackPath == "/" {
+		callbackPath = defaultCallbackPath
+	}
+
+	return port, callbackPath, nil
+}
 
-	if errUnmarshall != nil {
-		panic(errUnmarshall)
+// ClientResponse returns the client credentials as if they were registered.
+func (c StaticClientConfig) ClientResponse(endpoint string) ClientResponse {
+	return ClientResponse{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		Endpoint:     endpoint,
 	}
+}
+
+// GetRegisterEndpoint returns the registration endpoint of the IAM server
+// found in its discovery document.
+func GetRegisterEndpoint(httpClient *http.Client, endpoint string) (string, error) {
+	provider, err := oidc.Discover(httpClient, endpoint)
+	if err != nil {
+		return "", fmt.Errorf("register endpoint %w", err)
+	}
+
+	if provider.RegistrationEndpoint == "" {
+		return "", fmt.Errorf("%w: %s", errNoRegistrationEndpoint, endpoint)
+	}
+
+	return provider.RegistrationEndpoint, nil
+}
+
+// DiscoverProvider returns the OIDC metadata of the IAM server. If the server
+// does not expose a discovery document the INDIGO IAM endpoints are used.
+func DiscoverProvider(httpClient *http.Client, endpoint string) *oidc.ProviderMetadata {
+	provider, err := oidc.Discover(httpClient, endpoint)
+	if err != nil {
+		log.Warn().Err(err).Str("endpoint", endpoint).Msg("credentials - discovery, using default endpoints")
+		color.Yellow.Printf("==> Cannot discover IAM endpoints, using defaults for %s\n", endpoint)
 
-	return string(wk.RegisterEndpoint)
+		return oidc.Fallback(endpoint)
+	}
+
+	log.Debug().Str("issuer", provider.Issuer).Str("authorization",
+		provider.AuthorizationEndpoint).Str("token",
+		provider.TokenEndpoint).Str("jwks", provider.JwksURI).Msg("credentials - discovery")
+
+	return provider
 }
 
 func (t *InitClientConfig) InitClient(instance string) (endpoint string, clientResponse ClientResponse, passwd *memguard.Enclave, err error) { //nolint:funlen,cyclop,gocognit,lll
@@ -63,7 +137,7 @@ func (t *InitClientConfig) InitClient(instance string) (endpoint string, clientR
 
 	switch {
 	case err != nil && err.Error() != "no such file or directory":
-		tmpl, errParser := template.New("client").Parse(t.ClientTemplate)
+		tmpl, errParser := template.New("client").Funcs(iamTmpl.Funcs).Parse(t.ClientTemplate)
 		if errParser != nil {
 			panic(errParser)
 		}
@@ -84,8 +158,7 @@ func (t *InitClientConfig) InitClient(instance string) (endpoint string, clientR
 		log.Debug().Str("REFRESH_TOKEN", os.Getenv("REFRESH_TOKEN")).Msg("credentials")
 
 		if t.IAMServer == "" {
-			endpoint, err = t.Scanner.GetInputString("Insert the IAM endpoint",
-				"https://iam-demo.cloud.cnaf.infn.it")
+			endpoint, err = t.Scanner.GetInputString("Insert the IAM endpoint", defaultIAMServer)
 			if err != nil {
 				panic(err)
 			}
@@ -95,7 +168,10 @@ func (t *InitClientConfig) InitClient(instance string) (endpoint string, clientR
 			endpoint = t.IAMServer
 		}
 
-		register := GetRegisterEndpoint(endpoint)
+		register, errRegister := GetRegisterEndpoint(&t.HTTPClient, endpoint)
+		if errRegister != nil {
+			panic(errRegister)
+		}
 
 		log.Debug().Str("IAM register url", register).Msg("credentials")
 		color.Green.Printf("==> IAM register url: %s\n", register)
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	grantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	tokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+var (
+	errTokenExchange        = errors.New("token exchange failed")
+	errTokenExchangeGrant   = errors.New("the IAM server does not support the token exchange grant")
+	errTokenExchangeNoToken = errors.New("no access token in the token exchange response")
+)
+
+// TokenExchangeConfig of the RFC 8693 token exchange used to obtain a token
+// with the audience and the scopes accepted by the STS.
+type TokenExchangeConfig struct {
+	Enabled            bool     `mapstructure:"enabled" yaml:"enabled" desc:"exchange the access token (RFC 8693)"`
+	Audience           []string `mapstructure:"audience" yaml:"audience" desc:"audience of the exchanged token"`
+	Scopes             []string `mapstructure:"scopes" yaml:"scopes" desc:"scopes of the exchanged token"`
+	RequestedTokenType string   `mapstructure:"requestedTokenType" yaml:"requestedTokenType" desc:"type of the exchanged token, empty for an access token"`
+}
+
+// TokenExchangeResponse of the token endpoint.
+// Reference: https://datatracker.ietf.org/doc/html/rfc8693#section-2.2
+type TokenExchangeResponse struct {
+	AccessToken      string `json:"access_token"`
+	IssuedTokenType  string `json:"issued_token_type"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in,omitempty"`
+	Scope            string `json:"scope,omitempty"`
+	Error            string `json:"error,omitempty"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// storageToken returns the token to use with the STS: the exchanged one if
+// the token exchange is enabled, the given access token otherwise. The
+// returned token is verified.
+func (s *Server) storageToken(accessToken string) (string, error) {
+	token := accessToken
+
+	if s.TokenExchange.Enabled {
+		exchanged, err := s.exchangeToken(accessToken)
+		if err != nil {
+			return "", err
+		}
+
+		token = exchanged
+	}
+
+	if err := s.verifyToken(token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// exchangeToken performs the RFC 8693 token exchange of the subject token.
+func (s *Server) exchangeToken(subjectToken string) (string, error) { //nolint:funlen
+	provider := s.provider(s.Endpoint)
+
+	if !provider.SupportsGrant(grantTypeTokenExchange) {
+		return "", fmt.Errorf("%w: %s", errTokenExchangeGrant, provider.Issuer)
+	}
+
+	requestedTokenType := s.TokenExchange.RequestedTokenType
+	if requestedTokenType == "" {
+		requestedTokenType = tokenTypeAccessToken
+	}
+
+	values := url.Values{}
+	s.OAuth.SetValues(values)
+	values.Set("grant_type", grantTypeTokenExchange)
+	values.Set("subject_token", subjectToken)
+	values.Set("subject_token_type", tokenTypeAccessToken)
+	values.Set("requested_token_type", requestedTokenType)
+
+	if len(s.TokenExchange.Audience) != 0 {
+		values.Set("audience", strings.Join(s.TokenExchange.Audience, " "))
+	}
+
+	if len(s.TokenExchange.Scopes) != 0 {
+		values.Set("scope", strings.Join(s.TokenExchange.Scopes, " "))
+	}
+
+	log.Debug().Str("tokenEndpoint", provider.TokenEndpoint).Strs("audience",
+		s.TokenExchange.Audience).Strs("scopes", s.TokenExchange.Scopes).Str("requestedTokenType",
+		requestedTokenType).Msg("token exchange")
+
+	resp, rbody, err := doWithRetry(&s.Client.HTTPClient, "token exchange", true, func(ctx context.Context) (*http.Request, error) {
+		return newTokenRequest(ctx, provider.TokenEndpoint, values, s.CurClientResponse)
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", errTokenExchange, err)
+	}
+
+	var exchangeResp TokenExchangeResponse
+
+	if err := json.Unmarshal(rbody, &exchangeResp); err != nil {
+		return "", fmt.Errorf("%w: %s %v", errTokenExchange, resp.Status, err)
+	}
+
+	if exchangeResp.Error != "" {
+		return "", fmt.Errorf("%w: %s %s", errTokenExchange, exchangeResp.Error, exchangeResp.ErrorDescription)
+	}
+
+	if resp.StatusCode != http.StatusOK || exchangeResp.AccessToken == "" {
+		return "", fmt.Errorf("%w: %s", errTokenExchangeNoToken, resp.Status)
+	}
+
+	log.Debug().Str("issuedTokenType", exchangeResp.IssuedTokenType).Str("scope",
+		exchangeResp.Scope).Int("expiresIn", exchangeResp.ExpiresIn).Msg("token exchange")
+
+	return exchangeResp.AccessToken, nil
+}
@@ -1,22 +1,29 @@
 package core
 
 import (
-	"bytes"
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
 
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
 	"github.com/gookit/color"
 	"github.com/minio/minio-go/v6/pkg/credentials"
+	"github.com/minio/minio-go/v6/pkg/signer"
 	"github.com/minio/minio/pkg/auth"
 	"github.com/rs/zerolog/log"
 )
 
+var errNoSTSCredentials = errors.New("no credentials in the STS response")
+
 type RefreshTokenStruct struct {
 	RefreshToken     string `json:"refresh_token"`
 	AccessToken      string `json:"access_token"`
@@ -29,6 +36,7 @@ type IAMClientConfig struct {
 	Host        string
 	Port        int
 	ClientName  string
+	Scope       string
 }
 
 type ClientResponse struct {
@@ -49,13 +57,81 @@ func RandomState() string {
 	return base64.RawURLEncoding.EncodeToString(b)
 }
 
+// Supported STS actions.
+// Reference: https://github.com/minio/minio/tree/master/docs/sts
+const (
+	STSActionWebIdentity  = "AssumeRoleWithWebIdentity"
+	STSActionClientGrants = "AssumeRoleWithClientGrants"
+	STSActionLDAPIdentity = "AssumeRoleWithLDAPIdentity"
+	STSActionAssumeRole   = "AssumeRole"
+	stsVersion            = "2011-06-15"
+	stsRegion             = "us-east-1"
+)
+
+// STSConfig selects the STS action and its parameters.
+type STSConfig struct {
+	Action          string
+	LDAPUsername    string
+	LDAPPassword    string
+	AccessKey       string
+	SecretKey       string
+	RoleArn         string
+	RoleSessionName string
+	// Policy is the inline session policy that restricts the credentials
+	Policy string
+}
+
+// NeedsToken reports if the STS action needs an access token of the IAM server.
+func (c STSConfig) NeedsToken() bool {
+	switch c.Action {
+	case STSActionLDAPIdentity, STSActionAssumeRole:
+		return false
+	default:
+		return true
+	}
+}
+
+// StaticCredentials reports if rclone has to use the credentials obtained by
+// sts-wire instead of asking them to the STS with the access token.
+// rclone can ask only plain web identity credentials, so the session
+// parameters need the credentials obtained by sts-wire too.
+func (c STSConfig) StaticCredentials() bool {
+	if c.RoleArn != "" || c.RoleSessionName != "" || c.Policy != "" {
+		return true
+	}
+
+	return c.Action != "" && c.Action != STSActionWebIdentity
+}
+
+// setSessionParams adds the role and the session policy to the STS request.
+func (c STSConfig) setSessionParams(body url.Values) {
+	if c.RoleArn != "" {
+		body.Set("RoleArn", c.RoleArn)
+	}
+
+	if c.RoleSessionName != "" {
+		body.Set("RoleSessionName", c.RoleSessionName)
+	}
+
+	if c.Policy != "" {
+		body.Set("Policy", c.Policy)
+	}
+}
+
 // IAMProvider credential provider for oidc.
 type IAMProvider struct {
 	StsEndpoint       string
 	HTTPClient        *http.Client
 	Token             string
-	Creds             *AssumeRoleWithWebIdentityResponse
+	Creds             STSResponse
 	RefreshTokenRenew int
+	STS               STSConfig
+}
+
+// STSResponse is implemented by the responses of the supported STS actions.
+type STSResponse interface {
+	STSCredentials() auth.Credentials
+	STSPackedPolicySize() int
 }
 
 // AssumeRoleWithWebIdentityResponse the struct of the STS WebIdentity call response.
@@ -67,6 +143,149 @@ type AssumeRoleWithWebIdentityResponse struct {
 	} `xml:"ResponseMetadata,omitempty"`
 }
 
+// STSCredentials returns the temporary credentials.
+func (r *AssumeRoleWithWebIdentityResponse) STSCredentials() auth.Credentials {
+	return r.Result.Credentials
+}
+
+// STSPackedPolicySize returns the size of the session policy.
+func (r *AssumeRoleWithWebIdentityResponse) STSPackedPolicySize() int {
+	return r.Result.PackedPolicySize
+}
+
+// AssumeRoleWithClientGrantsResponse the struct of the STS ClientGrants call response.
+type AssumeRoleWithClientGrantsResponse struct {
+	XMLName          xml.Name           `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleWithClientGrantsResponse" json:"-"`
+	Result           ClientGrantsResult `xml:"AssumeRoleWithClientGrantsResult"`
+	ResponseMetadata struct {
+		RequestID string `xml:"RequestId,omitempty"`
+	} `xml:"ResponseMetadata,omitempty"`
+}
+
+// STSCredentials returns the temporary credentials.
+func (r *AssumeRoleWithClientGrantsResponse) STSCredentials() auth.Credentials {
+	return r.Result.Credentials
+}
+
+// STSPackedPolicySize returns the size of the session policy.
+func (r *AssumeRoleWithClientGrantsResponse) STSPackedPolicySize() int {
+	return r.Result.PackedPolicySize
+}
+
+// AssumeRoleWithLDAPResponse the struct of the STS LDAPIdentity call response.
+type AssumeRoleWithLDAPResponse struct {
+	XMLName          xml.Name           `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleWithLDAPIdentityResponse" json:"-"`
+	Result           LDAPIdentityResult `xml:"AssumeRoleWithLDAPIdentityResult"`
+	ResponseMetadata struct {
+		RequestID string `xml:"RequestId,omitempty"`
+	} `xml:"ResponseMetadata,omitempty"`
+}
+
+// STSCredentials returns the temporary credentials.
+func (r *AssumeRoleWithLDAPResponse) STSCredentials() auth.Credentials {
+	return r.Result.Credentials
+}
+
+// STSPackedPolicySize returns the size of the session policy.
+func (r *AssumeRoleWithLDAPResponse) STSPackedPolicySize() int {
+	return r.Result.PackedPolicySize
+}
+
+// AssumeRoleResponse the struct of the STS AssumeRole call response.
+type AssumeRoleResponse struct {
+	XMLName          xml.Name         `xml:"https://sts.amazonaws.com/doc/2011-06-15/ AssumeRoleResponse" json:"-"`
+	Result           AssumeRoleResult `xml:"AssumeRoleResult"`
+	ResponseMetadata struct {
+		RequestID string `xml:"RequestId,omitempty"`
+	} `xml:"ResponseMetadata,omitempty"`
+}
+
+// STSCredentials returns the temporary credentials.
+func (r *AssumeRoleResponse) STSCredentials() auth.Credentials {
+	return r.Result.Credentials
+}
+
+// STSPackedPolicySize returns the size of the session policy.
+func (r *AssumeRoleResponse) STSPackedPolicySize() int {
+	return r.Result.PackedPolicySize
+}
+
+// STSErrorResponse the struct of the STS error response.
+// Reference: https://docs.aws.amazon.com/STS/latest/APIReference/CommonErrors.html
+type STSErrorResponse struct {
+	XMLName xml.Name `xml:"ErrorResponse" json:"-"`
+	Error   struct {
+		Type    string `xml:"Type"`
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	} `xml:"Error"`
+	RequestID string `xml:"RequestId"`
+}
+
+// STSError is the error returned by the STS.
+type STSError struct {
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+}
+
+func (e *STSError) Error() string {
+	msg := e.Code
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	if e.RequestID != "" {
+		msg += fmt.Sprintf(" (status %d, request id %s)", e.StatusCode, e.RequestID)
+	} else {
+		msg += fmt.Sprintf(" (status %d)", e.StatusCode)
+	}
+
+	return msg
+}
+
+// Hint suggests what to check for the most common errors.
+func (e *STSError) Hint() string {
+	switch e.Code {
+	case "InvalidIdentityToken":
+		return "check that the issuer and the audience of the token are the ones accepted by the STS (IAMAudience, tokenExchange)"
+	case "ExpiredToken":
+		return "the token is expired, restart sts-wire to login again"
+	case "AccessDenied":
+		return "the role or the session policy do not allow the access (stsRoleArn, stsPolicyFile)"
+	case "MalformedPolicyDocument", "PackedPolicyTooLarge":
+		return "check the session policy (stsPolicyFile)"
+	case "InvalidClientTokenId", "SignatureDoesNotMatch":
+		return "check the access key and the secret key (stsAccessKey, STS_SECRET_KEY)"
+	case "InvalidParameterValue", "MissingParameter":
+		return "check the parameters of the STS action"
+	default:
+		return ""
+	}
+}
+
+// newSTSError decodes the error response of the STS. If the body is not an
+// STS error the HTTP status is used.
+func newSTSError(statusCode int, body []byte) *STSError {
+	var errResp STSErrorResponse
+
+	if err := xml.Unmarshal(body, &errResp); err != nil || errResp.Error.Code == "" {
+		return &STSError{ // nolint:exhaustivestruct
+			StatusCode: statusCode,
+			Code:       strings.ReplaceAll(http.StatusText(statusCode), " ", ""),
+			Message:    "unexpected response of the STS",
+		}
+	}
+
+	return &STSError{
+		StatusCode: statusCode,
+		Code:       errResp.Error.Code,
+		Message:    errResp.Error.Message,
+		RequestID:  errResp.RequestID,
+	}
+}
+
 // AssumedRoleUser - The identifiers for the temporary security credentials that
 // the operation returns. Please also see https://docs.aws.amazon.com/goto/WebAPI/sts-2011-06-15/AssumedRoleUser
 type AssumedRoleUser struct {
@@ -86,85 +305,159 @@ type WebIdentityResult struct {
 	SubjectFromWebIdentityToken string           `xml:",omitempty"`
 }
 
-// Retrieve credentials.
-func (t *IAMProvider) Retrieve() (credentials.Value, error) { // nolint:funlen
-	log.Debug().Int("RefreshTokenRenew",
-		t.RefreshTokenRenew).Str("RefreshTokenRenew string",
-		strconv.Itoa(t.RefreshTokenRenew*60)).Msg("IAM - Retrieve")
+// ClientGrantsResult - Contains the response to a successful AssumeRoleWithClientGrants
+// request, including temporary credentials that can be used to make MinIO API requests.
+type ClientGrantsResult struct {
+	AssumedRoleUser  AssumedRoleUser  `xml:",omitempty"`
+	Audience         string           `xml:",omitempty"`
+	Credentials      auth.Credentials `xml:",omitempty"`
+	PackedPolicySize int              `xml:",omitempty"`
+	Provider         string           `xml:",omitempty"`
+	SubjectFromToken string           `xml:",omitempty"`
+}
+
+// LDAPIdentityResult - Contains the response to a successful AssumeRoleWithLDAPIdentity
+// request, including temporary credentials that can be used to make MinIO API requests.
+type LDAPIdentityResult struct {
+	Credentials      auth.Credentials `xml:",omitempty"`
+	SubjectFromToken string           `xml:",omitempty"`
+	PackedPolicySize int              `xml:",omitempty"`
+}
 
+// AssumeRoleResult - Contains the response to a successful AssumeRole
+// request, including temporary credentials that can be used to make MinIO API requests.
+type AssumeRoleResult struct {
+	AssumedRoleUser  AssumedRoleUser  `xml:",omitempty"`
+	Credentials      auth.Credentials `xml:",omitempty"`
+	PackedPolicySize int              `xml:",omitempty"`
+}
+
+// request returns the STS request of the configured action. The parameters,
+// including the token and the password, are sent in the form encoded body.
+func (t *IAMProvider) request(ctx context.Context) (*http.Request, STSResponse, error) {
 	body := url.Values{}
-	body.Set("Action", "AssumeRoleWithWebIdentity")
-	body.Set("Version", "2011-06-15")
-	body.Set("WebIdentityToken", t.Token)
+	body.Set("Version", stsVersion)
 	body.Set("DurationSeconds", strconv.Itoa(t.RefreshTokenRenew*60))
 
-	log.Debug().Str("stsEndpoint", t.StsEndpoint).Str("body", body.Encode()).Msg("IAM")
+	var response STSResponse
+
+	switch t.STS.Action {
+	case "", STSActionWebIdentity:
+		body.Set("Action", STSActionWebIdentity)
+		body.Set("WebIdentityToken", t.Token)
+
+		response = &AssumeRoleWithWebIdentityResponse{} // nolint:exhaustivestruct
+	case STSActionClientGrants:
+		body.Set("Action", STSActionClientGrants)
+		body.Set("Token", t.Token)
 
-	url, errParse := url.Parse(
-		strings.Join(
-			[]string{t.StsEndpoint, "?", body.Encode()},
-			"",
-		),
-	)
-	if errParse != nil {
-		panic(errParse)
+		response = &AssumeRoleWithClientGrantsResponse{} // nolint:exhaustivestruct
+	case STSActionLDAPIdentity:
+		body.Set("Action", STSActionLDAPIdentity)
+		body.Set("LDAPUsername", t.STS.LDAPUsername)
+		body.Set("LDAPPassword", t.STS.LDAPPassword)
+
+		response = &AssumeRoleWithLDAPResponse{} // nolint:exhaustivestruct
+	case STSActionAssumeRole:
+		body.Set("Action", STSActionAssumeRole)
+
+		response = &AssumeRoleResponse{} // nolint:exhaustivestruct
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", validator.ErrNoValidSTSAction, t.STS.Action)
+	}
+
+	t.STS.setSessionParams(body)
+
+	log.Debug().Str("stsEndpoint", t.StsEndpoint).Str("action", body.Get("Action")).Str("roleArn",
+		t.STS.RoleArn).Str("roleSessionName", t.STS.RoleSessionName).Bool("sessionPolicy",
+		t.STS.Policy != "").Msg("IAM")
+
+	payload := body.Encode()
+
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodPost, t.StsEndpoint, strings.NewReader(payload))
+	if errReq != nil {
+		return nil, nil, fmt.Errorf("IAM retrieve %w", errReq)
 	}
 
-	log.Debug().Str("url", url.String()).Msg("IAM")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	req := http.Request{ // nolint:exhaustivestruct
-		Method: "POST",
-		URL:    url,
+	if t.STS.Action == STSActionAssumeRole {
+		// AssumeRole is signed with the long term credentials of the user
+		hashedPayload := sha256.Sum256([]byte(payload))
+		req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(hashedPayload[:]))
+
+		return signer.SignV4STS(*req, t.STS.AccessKey, t.STS.SecretKey, stsRegion), response, nil
 	}
 
-	resp, errDo := t.HTTPClient.Do(&req)
+	return req, response, nil
+}
+
+// Retrieve credentials.
+func (t *IAMProvider) Retrieve() (credentials.Value, error) { // nolint:funlen
+	log.Debug().Int("RefreshTokenRenew",
+		t.RefreshTokenRenew).Str("RefreshTokenRenew string",
+		strconv.Itoa(t.RefreshTokenRenew*60)).Msg("IAM - Retrieve")
+
+	var response STSResponse
+
+	resp, rbody, errDo := doWithRetry(t.HTTPClient, "STS", true, func(ctx context.Context) (*http.Request, error) {
+		req, curResponse, errRequest := t.request(ctx)
+		response = curResponse
+
+		return req, errRequest
+	})
 	if errDo != nil {
 		log.Err(errDo).Msg("IAM connect client")
 
 		if strings.Contains(errDo.Error(), "connection refused") {
 			color.Red.Println("IAM client connection")
-			color.Red.Println(fmt.Sprintf("==> Cannot connect to '%s'", url))
+			color.Red.Println(fmt.Sprintf("==> Cannot connect to '%s'", t.StsEndpoint))
 			color.Red.Println("==> Verify your IAM client")
-
-			panic("IAM client connection")
 		}
 
 		return credentials.Value{}, fmt.Errorf("IAM retrieve %w", errDo)
 	}
-	defer resp.Body.Close()
-
-	log.Debug().Int("statusCode", resp.StatusCode).Str("status", resp.Status).Msg("IAM")
 
-	var rbody bytes.Buffer
+	log.Debug().Int("statusCode", resp.StatusCode).Str("status", resp.Status).Int("size", len(rbody)).Msg("IAM")
 
-	_, errRead := rbody.ReadFrom(resp.Body)
-	if errRead != nil {
-		log.Err(errRead).Msg("IAM read body")
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		errSTS := newSTSError(resp.StatusCode, rbody)
+		log.Error().Str("code", errSTS.Code).Str("stsMessage", errSTS.Message).Str("requestID",
+			errSTS.RequestID).Int("statusCode", errSTS.StatusCode).Msg("IAM STS error")
 
-		return credentials.Value{}, fmt.Errorf("IAM retrieve %w", errRead)
+		return credentials.Value{}, errSTS
 	}
 
-	log.Debug().Str("body", rbody.String()).Msg("IAM")
-
-	t.Creds = &AssumeRoleWithWebIdentityResponse{}
-
-	errUnmarshall := xml.Unmarshal(rbody.Bytes(), t.Creds)
+	errUnmarshall := xml.Unmarshal(rbody, response)
 	if errUnmarshall != nil {
 		log.Err(errUnmarshall).Msg("IAM xml unmarshal")
 
 		return credentials.Value{}, fmt.Errorf("IAM retrieve %w", errUnmarshall)
 	}
 
-	log.Debug().Str("credentials", "acquired").Msg("IAM")
+	if response.STSCredentials().AccessKey == "" {
+		return credentials.Value{}, errNoSTSCredentials
+	}
+
+	t.Creds = response
+
+	log.Debug().Str("credentials", "acquired").Int("packedPolicySize",
+		t.Creds.STSPackedPolicySize()).Msg("IAM")
+
+	stsCredentials := t.Creds.STSCredentials()
 
 	return credentials.Value{ // nolint:exhaustivestruct
-		AccessKeyID:     t.Creds.Result.Credentials.AccessKey,
-		SecretAccessKey: t.Creds.Result.Credentials.SecretKey,
-		SessionToken:    t.Creds.Result.Credentials.SessionToken,
+		AccessKeyID:     stsCredentials.AccessKey,
+		SecretAccessKey: stsCredentials.SecretKey,
+		SessionToken:    stsCredentials.SessionToken,
 	}, nil
 }
 
 // IsExpired test.
 func (t *IAMProvider) IsExpired() bool {
-	return t.Creds.Result.Credentials.IsExpired()
+	if t.Creds == nil {
+		return true
+	}
+
+	return t.Creds.STSCredentials().IsExpired()
 }
@@ -4,8 +4,10 @@ import (
 	"bufio"
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -26,8 +28,15 @@ const (
 	checkExeWait     = 1 * time.Second
 	waitFileBusy     = 1 * time.Second
 	closeChannelWait = 2 * time.Second
+	// maxRcloneStartAttempts of rclone when it exits before serving the
+	// remote control API.
+	maxRcloneStartAttempts = 3
+	rcloneStartTimeout     = 30 * time.Second
+	rcloneStartPoll        = 250 * time.Millisecond
 )
 
+var errRcloneExited = errors.New("rclone exited")
+
 func CacheDir() (string, error) {
 	cacheDir, errCacheDir := os.UserCacheDir()
 	if errCacheDir != nil {
@@ -225,6 +234,56 @@ func PrepareRclone() error { // nolint: funlen,gocognit,cyclop
 	return nil
 }
 
+// defaultMountOptions returns the rclone mount options of the cache settings
+// and of the read-only, no modtime and non-empty settings.
+func defaultMountOptions(serverInstance *Server) rclone.MountOptions {
+	var options rclone.MountOptions
+
+	options.Set("--cache-dir", serverInstance.LocalCacheDir)
+	// TODO: fix -> increase the volume of log for no purpose
+	// "--debug-fuse",
+	// "--attr-timeout",
+	// "2m",
+	// CANNOT BE USED:
+	// - https://unix.stackexchange.com/questions/388722/git-repository-on-sshfs-unable-to-append-to-git-logs-head-invalid-argument
+	//"--write-back-cache",
+	// TODO: fix -> not working
+	// "--filter",
+	// "- *-checkpoint.ipynb",
+	// "--filter",
+	// "- .ipynb_checkpoints",
+
+	curCacheType := strings.ToLower(serverInstance.LocalCache)
+	if curCacheType != "off" {
+		options.Set("--vfs-write-wait", "2s")
+	}
+
+	if curCacheType == "full" {
+		options.Set("--vfs-read-wait", "55ms")
+		options.Set("--buffer-size", "2M")
+	}
+
+	serverInstance.Cache.setMountOptions(&options, curCacheType)
+	serverInstance.Bandwidth.setMountOptions(&options)
+
+	options.Set("--vfs-cache-mode", curCacheType)
+
+	if serverInstance.NoModtime {
+		options.Set("--no-modtime")
+	}
+
+	if serverInstance.ReadOnly {
+		options.Set("--read-only")
+	}
+
+	// Checked at startup, rclone refuses a non-empty mount point on Linux
+	if serverInstance.AllowNonEmpty && runtime.GOOS != "windows" {
+		options.Set("--allow-non-empty")
+	}
+
+	return options
+}
+
 func MountVolume(serverInstance *Server) (*exec.Cmd, chan error, string, error) { // nolint: funlen,gocognit,gocyclo
 	instance := serverInstance.Instance
 	remotePath := serverInstance.RemotePath
@@ -283,6 +342,13 @@ func MountVolume(serverInstance *Server) (*exec.Cmd, chan error, string, error)
 		return nil, nil, "", fmt.Errorf("local path abs: %w", errLocalPath)
 	}
 
+	tlsArgs, errTLS := serverInstance.TLS.RcloneArgs(configPath)
+	if errTLS != nil {
+		log.Err(errTLS).Msg("rclone - mount")
+
+		return nil, nil, "", fmt.Errorf("rclone tls: %w", errTLS)
+	}
+
 	commandArgs := []string{
 		"--config",
 		configPathAbs,
@@ -292,7 +358,10 @@ func MountVolume(serverInstance *Server) (*exec.Cmd, chan error, string, error)
 		"--log-level",
 		"DEBUG",
 		"--use-json-log",
-		"--no-check-certificate",
+	}
+	commandArgs = append(commandArgs, tlsArgs...)
+	commandArgs = append(commandArgs, serverInstance.Network.RcloneArgs()...)
+	commandArgs = append(commandArgs,
 		"--cache-db-purge",
 		/*
 		 * https://rclone.org/docs/#use-server-modtime
@@ -318,65 +387,47 @@ func MountVolume(serverInstance *Server) (*exec.Cmd, chan error, string, error)
 		"mount",
 		conf,
 		localPathAbs,
-	}
+	)
 
-	commandFlags := []string{
-		"--cache-dir",
-		serverInstance.LocalCacheDir,
-		// TODO: fix -> increase the volume of log for no purpose
-		// "--debug-fuse",
-		// "--attr-timeout",
-		// "2m",
-		// CANNOT BE USED:
-		// - https://unix.stackexchange.com/questions/388722/git-repository-on-sshfs-unable-to-append-to-git-logs-head-invalid-argument
-		//"--write-back-cache",
-		// TODO: fix -> not working
-		// "--filter",
-		// "- *-checkpoint.ipynb",
-		// "--filter",
-		// "- .ipynb_checkpoints",
+	if errCache := prepareCacheDir(serverInstance); errCache != nil {
+		return nil, nil, "", fmt.Errorf("rclone cache: %w", errCache)
 	}
 
-	curCacheType := strings.ToLower(serverInstance.LocalCache)
-	if curCacheType != "off" {
-		err := os.RemoveAll(serverInstance.LocalCacheDir)
-		if err != nil && !os.IsNotExist(err) {
-			panic(err)
+	mountOptions := defaultMountOptions(serverInstance)
+	mountOptions.Merge(serverInstance.MountOptions)
+	commandArgs = append(commandArgs, mountOptions.Args()...)
+
+	// NewRC releases the port before rclone binds it and another process can
+	// take it meanwhile: rclone then exits at the start and it is started
+	// again on another port.
+	for attempt := 1; ; attempt++ {
+		rc, errRC := rclone.NewRC()
+		if errRC != nil {
+			log.Err(errRC).Msg("rclone - mount")
+
+			return nil, nil, "", errRC
 		}
 
-		commandFlags = append(commandFlags, "--vfs-write-back", "10s")
-		commandFlags = append(commandFlags, "--vfs-write-wait", "2s")
-	}
+		rcloneCmd, cmdErr := startRclone(rcloneFile, append(rc.Args(), commandArgs...), rc, serverInstance)
 
-	switch curCacheType {
-	case "off":
-		commandFlags = append(commandFlags, "--vfs-cache-mode", "off")
-	case "minimal":
-		commandFlags = append(commandFlags, "--vfs-cache-mode", "minimal")
-	case "writes":
-		commandFlags = append(commandFlags, "--vfs-cache-mode", "writes")
-	case "full":
-		commandFlags = append(commandFlags, "--vfs-read-wait", "55ms")
-		commandFlags = append(commandFlags, "--vfs-read-ahead", "8M")
-		commandFlags = append(commandFlags, "--buffer-size", "2M")
-		commandFlags = append(commandFlags, "--vfs-cache-mode", "full")
-	}
+		errWait := waitRC(rc, rcloneCmd, cmdErr)
+		if errWait == nil {
+			serverInstance.rc = rc
 
-	if serverInstance.NoModtime {
-		commandFlags = append(commandFlags, "--no-modtime")
-	}
+			return rcloneCmd, cmdErr, logPath, nil
+		}
 
-	if serverInstance.ReadOnly {
-		commandFlags = append(commandFlags, "--read-only")
-	}
+		log.Warn().Err(errWait).Int("attempt", attempt).Msg("rclone - mount")
 
-	if serverInstance.MountNewFlags != "" {
-		newFlags := strings.Split(serverInstance.MountNewFlags, " ")
-		commandArgs = append(commandArgs, newFlags...)
-	} else {
-		commandArgs = append(commandArgs, commandFlags...)
+		if attempt == maxRcloneStartAttempts {
+			return nil, nil, "", fmt.Errorf("rclone start, see %s: %w", logPath, errWait)
+		}
 	}
+}
 
+// startRclone starts rclone with the remote control API and returns the
+// channel of its exit errors.
+func startRclone(rcloneFile string, commandArgs []string, rc rclone.RC, serverInstance *Server) (*exec.Cmd, chan error) { // nolint: funlen,gocognit,gocyclo
 	log.Debug().Str("command",
 		rcloneFile).Interface("args",
 		commandArgs,
@@ -384,6 +435,9 @@ func MountVolume(serverInstance *Server) (*exec.Cmd, chan error, string, error)
 
 	rcloneCmd := exec.Command(rcloneFile, commandArgs...)
 
+	rcloneCmd.Env = append(os.Environ(), rc.Env()...)
+	rcloneCmd.Env = append(rcloneCmd.Env, serverInstance.Network.RcloneEnv()...)
+
 	cmdStdout, err := rcloneCmd.StderrPipe()
 	if err != nil {
 		log.Error().Err(err).Msg("stdout pipe")
@@ -496,7 +550,35 @@ func MountVolume(serverInstance *Server) (*exec.Cmd, chan error, string, error)
 	}
 	go cmdErrorCheck()
 
-	return rcloneCmd, cmdErr, logPath, nil
+	return rcloneCmd, cmdErr
+}
+
+// waitRC waits for the remote control API of the started rclone, checking the
+// pid, since another process can answer on the port. It returns an error if
+// rclone exits meanwhile and nil if it is still starting after
+// rcloneStartTimeout, the calls to the API then fail until it answers.
+func waitRC(rc rclone.RC, rcloneCmd *exec.Cmd, cmdErr chan error) error {
+	timeout := time.After(rcloneStartTimeout)
+
+	for {
+		if pid, err := rc.PID(context.Background()); err == nil && pid == rcloneCmd.Process.Pid {
+			return nil
+		}
+
+		select {
+		case errExit, ok := <-cmdErr:
+			if !ok || errExit == nil {
+				return errRcloneExited
+			}
+
+			return fmt.Errorf("%w: %s", errRcloneExited, errExit)
+		case <-timeout:
+			log.Warn().Str("url", rc.URL).Msg("rclone - remote control not answering")
+
+			return nil
+		case <-time.After(rcloneStartPoll):
+		}
+	}
 }
 
 type RcloneLogErrorMsg struct {
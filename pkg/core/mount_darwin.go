@@ -3,23 +3,100 @@
 package core
 
 import (
-	"syscall"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 
+	"github.com/DODAS-TS/sts-wire/pkg/mountinfo"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sys/unix"
 )
 
-func unmount(path string) error {
-	err := syscall.Unmount(path, 0)
+var errNoProc = errors.New("not available on macOS, give the mount point")
 
-	log.Debug().Err(err).Msg("umount - linux")
+// checkMountpoint verify if a path is a mount point.
+// Note: this is a laxy check because it not detects bind mounts.
+func checkMountpoint(path string) (bool, error) {
+	var st unix.Stat_t
 
-	if err != nil {
-		if err != syscall.EINVAL {
-			return err
-		} else {
-			return nil // syscall.EINVAL for invalid flag (because it is not a mount point)
+	if err := unix.Lstat(path, &st); err != nil {
+		if err == unix.ENOENT {
+			// ENOENT -> not a mount point
+			return false, nil
 		}
+
+		return false, &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+
+	dev := st.Dev
+
+	parent := filepath.Dir(path)
+	if err := unix.Lstat(parent, &st); err != nil {
+		return false, &os.PathError{Op: "stat", Path: parent, Err: err}
+	}
+
+	log.Debug().Int("dev", int(dev)).Int("parent dev", int(st.Dev)).Msg("checkMountpoint")
+
+	if dev != st.Dev {
+		// If the Device differs from that of parent, it is a mount point
+		return true, nil
 	}
 
-	return nil
+	return false, nil
+}
+
+// checkRcloneMount checks that the path is a mount point, the type and the
+// source of the filesystem are not available without /proc.
+func checkRcloneMount(path string, source string) (mountinfo.Mount, error) {
+	mounted, err := checkMountpoint(path)
+	if err != nil {
+		return mountinfo.Mount{}, err
+	}
+
+	if !mounted {
+		return mountinfo.Mount{}, fmt.Errorf("%w: %s", mountinfo.ErrNotMounted, path)
+	}
+
+	return mountinfo.Mount{MountPoint: path, FSType: rcloneFuseType, Source: source}, nil // nolint:exhaustivestruct
+}
+
+// unmountCommands are the commands that unmount a macFUSE mount point as a
+// user, tried in order.
+func unmountCommands(path string) [][]string {
+	return [][]string{{"umount", path}, {"diskutil", "unmount", path}}
+}
+
+// lazyUnmountCommands force the unmount even if the mount point is busy.
+func lazyUnmountCommands(path string) [][]string {
+	return [][]string{{"umount", "-f", path}, {"diskutil", "unmount", "force", path}}
+}
+
+// detach is the last resort, the forced unmount syscall.
+func detach(path string) error {
+	err := unix.Unmount(path, unix.MNT_FORCE)
+
+	log.Debug().Err(err).Msg("umount - detach")
+
+	if errors.Is(err, unix.EINVAL) {
+		return nil // syscall.EINVAL for invalid flag (because it is not a mount point)
+	}
+
+	return err
+}
+
+// busyProcesses is not available without /proc.
+func busyProcesses(mountPoint string) ([]busyProcess, error) {
+	return nil, errNoProc
+}
+
+// findMount is not available without /proc, the mount points are not
+// checked at startup.
+func findMount(path string) (mountinfo.Mount, bool, error) {
+	return mountinfo.Mount{}, false, nil
+}
+
+// instanceMountPoints is not available without /proc.
+func instanceMountPoints(instance string) ([]string, error) {
+	return nil, errNoProc
 }
@@ -4,26 +4,154 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 
+	"github.com/DODAS-TS/sts-wire/pkg/mountinfo"
 	"github.com/rs/zerolog/log"
 )
 
-func unmount(path string) error {
+const procDir = "/proc"
+
+// unmountCommands are the commands that unmount a FUSE mount point as a user,
+// tried in order: fusermount of FUSE 2, then of FUSE 3.
+func unmountCommands(path string) [][]string {
+	return [][]string{{"fusermount", "-u", path}, {"fusermount3", "-u", path}}
+}
+
+// lazyUnmountCommands detach the mount point even if busy, the files in use
+// stay available to the processes until they are closed.
+func lazyUnmountCommands(path string) [][]string {
+	return [][]string{{"fusermount", "-u", "-z", path}, {"fusermount3", "-u", "-z", path}}
+}
+
+// detach is the last resort, the lazy unmount syscall, allowed only to root.
+func detach(path string) error {
 	// Detach info: https://man7.org/linux/man-pages/man2/umount2.2.html
 	err := syscall.Unmount(path, syscall.MNT_DETACH)
 
-	log.Debug().Err(err).Msg("umount - linux")
+	log.Debug().Err(err).Msg("umount - detach")
+
+	if errors.Is(err, syscall.EINVAL) {
+		// syscall.EINVAL target is not a mount point.
+		return nil
+	}
+
+	return err
+}
 
+// busyProcesses lists the processes with the working directory, the root or
+// open files in the mount point, from /proc. The processes of the other users
+// are visible only to root.
+func busyProcesses(mountPoint string) ([]busyProcess, error) {
+	entries, err := os.ReadDir(procDir)
 	if err != nil {
-		if err != syscall.EINVAL {
-			return err
-		} else {
-			// syscall.EINVAL target is not a mount point.
-			// - https://man7.org/linux/man-pages/man2/umount.2.html
-			return nil
+		return nil, fmt.Errorf("busy processes %w", err)
+	}
+
+	processes := []busyProcess{}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		procPath := filepath.Join(procDir, entry.Name())
+		uses := []string{}
+
+		for _, link := range []string{"cwd", "root", "exe"} {
+			if target, err := os.Readlink(filepath.Join(procPath, link)); err == nil && inMountPoint(mountPoint, target) {
+				uses = append(uses, link+" "+target)
+			}
+		}
+
+		fds, _ := os.ReadDir(filepath.Join(procPath, "fd"))
+		for _, fd := range fds {
+			if target, err := os.Readlink(filepath.Join(procPath, "fd", fd.Name())); err == nil && inMountPoint(mountPoint, target) {
+				uses = append(uses, "file "+target)
+			}
+		}
+
+		if len(uses) == 0 {
+			continue
+		}
+
+		command, _ := os.ReadFile(filepath.Join(procPath, "comm"))
+		processes = append(processes, busyProcess{PID: pid, Command: strings.TrimSpace(string(command)), Uses: uses})
+	}
+
+	return processes, nil
+}
+
+// checkMountpoint reports whether a path is a mount point, also of a bind
+// mount, from mountinfo.
+func checkMountpoint(path string) (bool, error) {
+	pathAbs, err := filepath.Abs(path)
+	if err != nil {
+		return false, fmt.Errorf("check mount point %w", err)
+	}
+
+	mount, found, err := findMount(pathAbs)
+
+	log.Debug().Str("path", pathAbs).Bool("mounted", found).Int("mountID", mount.ID).Msg("checkMountpoint")
+
+	return found, err
+}
+
+// checkRcloneMount checks that rclone is mounted at the path with the source,
+// and returns the mount with its id and options.
+func checkRcloneMount(path string, source string) (mountinfo.Mount, error) {
+	mounts, err := mountinfo.Read()
+	if err != nil {
+		return mountinfo.Mount{}, fmt.Errorf("check mount point %w", err)
+	}
+
+	resolved, err := mountinfo.Resolve(path)
+	if err != nil {
+		return mountinfo.Mount{}, fmt.Errorf("check mount point %w", err)
+	}
+
+	return mountinfo.Check(mounts, resolved, rcloneFuseType, source)
+}
+
+// findMount returns the filesystem mounted at the path, if any, also behind a
+// symbolic link.
+func findMount(path string) (mountinfo.Mount, bool, error) {
+	mounts, err := mountinfo.Read()
+	if err != nil {
+		return mountinfo.Mount{}, false, fmt.Errorf("find mount %w", err)
+	}
+
+	resolved, err := mountinfo.Resolve(path)
+	if err != nil {
+		return mountinfo.Mount{}, false, fmt.Errorf("find mount %w", err)
+	}
+
+	mount, found := mountinfo.Find(mounts, resolved)
+
+	return mount, found, nil
+}
+
+// instanceMountPoints returns the rclone mount points of an instance.
+func instanceMountPoints(instance string) ([]string, error) {
+	mounts, err := mountinfo.Read()
+	if err != nil {
+		return nil, fmt.Errorf("mount points %w", err)
+	}
+
+	mountPoints := []string{}
+
+	for _, mount := range mounts {
+		if isInstanceMount(mount, instance) {
+			mountPoints = append(mountPoints, mount.MountPoint)
 		}
 	}
 
-	return nil
+	return mountPoints, nil
 }
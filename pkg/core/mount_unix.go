@@ -3,44 +3,122 @@
 package core
 
 import (
-	"os"
+	"errors"
+	"fmt"
+	"os/exec"
 	"path/filepath"
+	"strings"
 
+	"github.com/gookit/color"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/sys/unix"
 )
 
 const (
 	rcloneExeString = "rclone"
 )
 
-// checkMountpoint verify if a path is a mount point.
-// Note: this is a laxy check because it not detects bind mounts.
-func checkMountpoint(path string) (bool, error) {
-	var st unix.Stat_t
+var (
+	errUnmount          = errors.New("cannot unmount")
+	errNoUnmountCommand = errors.New("no unmount command found")
+)
+
+// busyProcess is a process that uses files of a mount point.
+type busyProcess struct {
+	PID     int
+	Command string
+	// Uses are the working directory, the root or the files in the mount point
+	Uses []string
+}
+
+// inMountPoint reports whether the path is the mount point or inside it.
+func inMountPoint(mountPoint string, path string) bool {
+	return path == mountPoint || strings.HasPrefix(path, mountPoint+string(filepath.Separator))
+}
+
+// runUnmountCommands runs the unmount commands found in the PATH until one
+// succeeds.
+func runUnmountCommands(commands [][]string) error {
+	err := errNoUnmountCommand
 
-	if err := unix.Lstat(path, &st); err != nil {
-		if err == unix.ENOENT {
-			// ENOENT -> not a mount point
-			return false, nil
+	for _, command := range commands {
+		if _, errLook := exec.LookPath(command[0]); errLook != nil {
+			continue
 		}
 
-		return false, &os.PathError{Op: "stat", Path: path, Err: err}
+		output, errRun := exec.Command(command[0], command[1:]...).CombinedOutput() // nolint:gosec
+
+		log.Debug().Err(errRun).Strs("command", command).Str("output", string(output)).Msg("umount")
+
+		if errRun == nil {
+			return nil
+		}
+
+		err = fmt.Errorf("%s: %s", command[0], strings.TrimSpace(string(output)))
+	}
+
+	return err
+}
+
+// printBusyProcesses shows the processes that use the mount point.
+func printBusyProcesses(mountPoint string) {
+	processes, err := busyProcesses(mountPoint)
+	if err != nil {
+		log.Debug().Err(err).Msg("umount - busy processes")
+
+		return
 	}
 
-	dev := st.Dev
+	if len(processes) == 0 {
+		return
+	}
+
+	color.Yellow.Printf("==> %s is used by:\n", mountPoint)
+
+	for _, process := range processes {
+		log.Info().Int("pid", process.PID).Str("command", process.Command).Strs("uses",
+			process.Uses).Msg("umount - busy")
+		fmt.Printf("    %d %s: %s\n", process.PID, process.Command, strings.Join(process.Uses, ", "))
+	}
+}
+
+// unmount unmounts a mount point left by rclone, if mounted: first with a
+// regular unmount and, if it fails, e.g. because the mount point is busy,
+// with a lazy one after listing the processes that use it.
+func unmount(path string) error {
+	pathAbs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("umount %w", err)
+	}
+
+	// A stale mount point fails the check with ENOTCONN
+	if mounted, errCheck := checkMountpoint(pathAbs); errCheck == nil && !mounted {
+		log.Debug().Str("path", pathAbs).Msg("umount - not a mount point")
+
+		return nil
+	}
+
+	errRegular := runUnmountCommands(unmountCommands(pathAbs))
+	if errRegular == nil {
+		return nil
+	}
+
+	log.Warn().Err(errRegular).Str("path", pathAbs).Msg("umount")
+	color.Yellow.Printf("==> Cannot unmount %s: %s\n", pathAbs, errRegular)
+
+	printBusyProcesses(pathAbs)
+
+	color.Yellow.Printf("==> Trying a lazy unmount of %s...\n", pathAbs)
 
-	parent := filepath.Dir(path)
-	if err := unix.Lstat(parent, &st); err != nil {
-		return false, &os.PathError{Op: "stat", Path: parent, Err: err}
+	errLazy := runUnmountCommands(lazyUnmountCommands(pathAbs))
+	if errLazy == nil {
+		return nil
 	}
 
-	log.Debug().Int("dev", int(dev)).Int("parent dev", int(st.Dev)).Msg("checkMountpoint")
+	log.Warn().Err(errLazy).Str("path", pathAbs).Msg("umount - lazy")
 
-	if dev != st.Dev {
-		// If the Device differs from that of parent, it is a mount point
-		return true, nil
+	if errDetach := detach(pathAbs); errDetach != nil {
+		return fmt.Errorf("%w %s: %s, %v", errUnmount, pathAbs, errLazy, errDetach)
 	}
 
-	return false, nil
+	return nil
 }
@@ -1,7 +1,10 @@
+//go:build windows
 // +build windows
+
 package core
 
 import (
+	"github.com/DODAS-TS/sts-wire/pkg/mountinfo"
 	"github.com/rs/zerolog/log"
 )
 
@@ -15,8 +18,28 @@ func checkMountpoint(path string) (bool, error) {
 	return true, nil
 }
 
+// checkRcloneMount is not available on Windows.
+func checkRcloneMount(path string, source string) (mountinfo.Mount, error) {
+	log.Warn().Msg("checkRcloneMount not yet implemented on windows...")
+
+	return mountinfo.Mount{MountPoint: path, Source: source}, nil // nolint:exhaustivestruct
+}
+
+// unmount has nothing to do, WinFsp removes the mount point at the exit of
+// rclone.
 func unmount(path string) error {
-	log.Warn().Msg("unmount not yet implemented on windows...")
+	log.Debug().Str("path", path).Msg("umount - windows, unmounted at the exit of rclone")
 
 	return nil
 }
+
+// findMount is not available on Windows, the mount points are not checked at
+// startup.
+func findMount(path string) (mountinfo.Mount, bool, error) {
+	return mountinfo.Mount{}, false, nil
+}
+
+// instanceMountPoints is not available on Windows.
+func instanceMountPoints(instance string) ([]string, error) {
+	return nil, errNoUnmount
+}
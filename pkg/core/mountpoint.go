@@ -0,0 +1,146 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/DODAS-TS/sts-wire/pkg/mountinfo"
+	"github.com/gookit/color"
+	"github.com/rs/zerolog/log"
+)
+
+const rcloneFuseType = "fuse.rclone"
+
+var (
+	errMountPointInUse    = errors.New("the mount point is in use")
+	errMountPointNotEmpty = errors.New("the mount point is not empty, set allowNonEmpty to mount over its files")
+	errStaleMount         = errors.New("the mount point is left by a previous run")
+)
+
+// mountSource returns the source of the rclone mount, <instance>:<remote path>
+// as rclone shows it, without the leading and trailing slashes.
+func (s *Server) mountSource() string {
+	return s.Instance + ":" + strings.Trim(s.RemotePath, "/")
+}
+
+// isInstanceMount reports whether the mount is a rclone mount of the instance,
+// whose source is <instance>:<remote path>.
+func isInstanceMount(mount mountinfo.Mount, instance string) bool {
+	return mount.FSType == rcloneFuseType && strings.HasPrefix(mount.Source, instance+":")
+}
+
+// mountOwner describes the user that mounted a FUSE filesystem, empty if not
+// known.
+func mountOwner(mount mountinfo.Mount) string {
+	uid, found := mount.SuperOptions["user_id"]
+	if !found {
+		return ""
+	}
+
+	if owner, err := user.LookupId(uid); err == nil {
+		return fmt.Sprintf("%s (uid %s)", owner.Username, uid)
+	}
+
+	return "uid " + uid
+}
+
+// describeMount returns the source, the type and the owner of a mount.
+func describeMount(mount mountinfo.Mount) string {
+	description := fmt.Sprintf("%s (%s)", mount.Source, mount.FSType)
+
+	if owner := mountOwner(mount); owner != "" {
+		description += " mounted by " + owner
+	}
+
+	return description
+}
+
+// isStale reports whether the path is a FUSE mount point without its process,
+// e.g. after a crash of rclone.
+func isStale(path string) bool {
+	_, err := os.Stat(path)
+
+	return errors.Is(err, syscall.ENOTCONN) || errors.Is(err, syscall.ECONNABORTED)
+}
+
+// prepareMountPoint checks the mount point before mounting the volume. A
+// mount left by a previous run of the instance is unmounted, asking the user
+// unless unmountStale is set; the other mounts are refused, as the folders
+// with files unless allowNonEmpty is set.
+func prepareMountPoint(cfg Config, wizard *Wizard) error {
+	mountPoint, err := filepath.Abs(cfg.LocalMountPoint)
+	if err != nil {
+		return fmt.Errorf("mount point %w", err)
+	}
+
+	mount, mounted, err := findMount(mountPoint)
+	if err != nil {
+		log.Warn().Err(err).Str("mountPoint", mountPoint).Msg("prepareMountPoint - mounts not checked")
+	}
+
+	if mounted {
+		log.Debug().Str("mountPoint", mountPoint).Interface("mount", mount).Msg("prepareMountPoint - mounted")
+
+		if err := prepareMounted(cfg, mountPoint, mount, wizard); err != nil {
+			return err
+		}
+	}
+
+	if cfg.AllowNonEmpty {
+		return nil
+	}
+
+	entries, err := os.ReadDir(mountPoint)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("mount point %w", err)
+	}
+
+	if len(entries) != 0 {
+		return fmt.Errorf("%w: %s", errMountPointNotEmpty, mountPoint)
+	}
+
+	return nil
+}
+
+// prepareMounted unmounts the mount point if it was left by the instance.
+func prepareMounted(cfg Config, mountPoint string, mount mountinfo.Mount, wizard *Wizard) error {
+	if !isInstanceMount(mount, cfg.InstanceName) {
+		return fmt.Errorf("%w: %s is mounted from %s", errMountPointInUse, mountPoint, describeMount(mount))
+	}
+
+	var status controlStatus
+	if err := controlRequest("."+cfg.InstanceName, http.MethodGet, "/status", nil, &status); err == nil {
+		return fmt.Errorf("%w: %s is mounted by the running instance %s (pid %d)", errMountPointInUse,
+			mountPoint, cfg.InstanceName, status.PID)
+	}
+
+	state := "still served by a rclone process"
+	if isStale(mountPoint) {
+		state = "stale"
+	}
+
+	color.Yellow.Printf("==> %s is a mount of %s left by a previous run, %s\n", mountPoint, describeMount(mount), state)
+	log.Warn().Str("mountPoint", mountPoint).Str("source", mount.Source).Str("state", state).Msg(
+		"prepareMountPoint - left by a previous run")
+
+	if !cfg.UnmountStale {
+		if !isTerminal(os.Stdin) || !wizard.confirm("Unmount it?", true) {
+			return fmt.Errorf("%w: %s, run sts-wire umount %s or set unmountStale", errStaleMount, mountPoint,
+				cfg.InstanceName)
+		}
+	}
+
+	if err := unmount(mountPoint); err != nil {
+		return fmt.Errorf("%w: %s", errStaleMount, err)
+	}
+
+	color.Green.Printf("==> %s unmounted\n", mountPoint)
+
+	return nil
+}
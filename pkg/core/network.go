@@ -0,0 +1,197 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+const (
+	defaultConnectTimeout      = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultResponseTimeout     = 30 * time.Second
+	defaultKeepAlive           = 30 * time.Second
+	idleConnTimeout            = 90 * time.Second
+	maxIdleConns               = 10
+)
+
+var (
+	errNoValidProxy     = errors.New("no valid proxy URL")
+	errNoValidIPVersion = errors.New("no valid IP version, use 4 or 6")
+)
+
+// NetworkConfig of the outbound connections to the IAM server, the STS and
+// the S3 endpoint. Without an explicit proxy the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables are used.
+type NetworkConfig struct {
+	HTTPProxy           string
+	HTTPSProxy          string
+	NoProxy             string
+	ConnectTimeout      time.Duration
+	TLSHandshakeTimeout time.Duration
+	ResponseTimeout     time.Duration
+	// IPVersion forces IPv4 ("4") or IPv6 ("6"), empty for both
+	IPVersion string
+}
+
+// Validate checks the proxy URLs and the IP version.
+func (c NetworkConfig) Validate() error {
+	for _, proxy := range []string{c.HTTPProxy, c.HTTPSProxy} {
+		if proxy == "" {
+			continue
+		}
+
+		proxyURL, err := url.Parse(proxy)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("%w: '%s'", errNoValidProxy, proxy)
+		}
+
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return fmt.Errorf("%w: '%s'", errNoValidProxy, proxy)
+		}
+	}
+
+	switch c.IPVersion {
+	case "", "4", "6":
+		return nil
+	default:
+		return fmt.Errorf("%w: '%s'", errNoValidIPVersion, c.IPVersion)
+	}
+}
+
+// explicitProxy reports if a proxy is configured, the NO_PROXY list alone
+// does not replace the proxies of the environment.
+func (c NetworkConfig) explicitProxy() bool {
+	return c.HTTPProxy != "" || c.HTTPSProxy != ""
+}
+
+// proxyConfig returns the configured proxies, the unset ones are read from
+// the environment. The HTTP proxy is used for HTTPS too if none is given.
+func (c NetworkConfig) proxyConfig() *httpproxy.Config {
+	proxyConfig := httpproxy.FromEnvironment()
+
+	if c.HTTPProxy != "" {
+		proxyConfig.HTTPProxy = c.HTTPProxy
+	}
+
+	if c.HTTPSProxy != "" {
+		proxyConfig.HTTPSProxy = c.HTTPSProxy
+	}
+
+	if proxyConfig.HTTPSProxy == "" {
+		proxyConfig.HTTPSProxy = proxyConfig.HTTPProxy
+	}
+
+	if c.NoProxy != "" {
+		proxyConfig.NoProxy = c.NoProxy
+	}
+
+	return proxyConfig
+}
+
+// Proxy returns the proxy selection function of the transport.
+func (c NetworkConfig) Proxy() func(*http.Request) (*url.URL, error) {
+	if !c.explicitProxy() && c.NoProxy == "" {
+		return http.ProxyFromEnvironment
+	}
+
+	proxyFunc := c.proxyConfig().ProxyFunc()
+
+	return func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+}
+
+// network returns the network of the dialer for the IP version.
+func (c NetworkConfig) network(network string) string {
+	if c.IPVersion == "" {
+		return network
+	}
+
+	return network + c.IPVersion
+}
+
+func durationOrDefault(value time.Duration, def time.Duration) time.Duration {
+	if value <= 0 {
+		return def
+	}
+
+	return value
+}
+
+// Transport returns the HTTP transport with the network settings.
+func (c NetworkConfig) Transport() *http.Transport {
+	dialer := &net.Dialer{ // nolint:exhaustivestruct
+		Timeout:   durationOrDefault(c.ConnectTimeout, defaultConnectTimeout),
+		KeepAlive: defaultKeepAlive,
+	}
+
+	return &http.Transport{ // nolint:exhaustivestruct
+		Proxy: c.Proxy(),
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, c.network(network), addr)
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		TLSHandshakeTimeout:   durationOrDefault(c.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ResponseHeaderTimeout: durationOrDefault(c.ResponseTimeout, defaultResponseTimeout),
+	}
+}
+
+// RcloneEnv returns the proxy environment of the rclone process, only the
+// variables with a value.
+func (c NetworkConfig) RcloneEnv() []string {
+	if !c.explicitProxy() && c.NoProxy == "" {
+		return nil
+	}
+
+	proxyConfig := c.proxyConfig()
+	env := []string{}
+
+	for _, variable := range [][2]string{
+		{"HTTP_PROXY", proxyConfig.HTTPProxy},
+		{"HTTPS_PROXY", proxyConfig.HTTPSProxy},
+		{"NO_PROXY", proxyConfig.NoProxy},
+	} {
+		if variable[1] == "" {
+			continue
+		}
+
+		env = append(env, variable[0]+"="+variable[1], strings.ToLower(variable[0])+"="+variable[1])
+	}
+
+	return env
+}
+
+// RcloneArgs returns the rclone flags with the configured timeouts and the
+// IP version, rclone keeps its defaults for the others.
+func (c NetworkConfig) RcloneArgs() []string {
+	args := []string{}
+
+	if c.ConnectTimeout > 0 {
+		args = append(args, "--contimeout", c.ConnectTimeout.String())
+	}
+
+	if c.ResponseTimeout > 0 {
+		args = append(args, "--timeout", c.ResponseTimeout.String())
+	}
+
+	switch c.IPVersion {
+	case "4":
+		args = append(args, "--bind", "0.0.0.0")
+	case "6":
+		args = append(args, "--bind", "::")
+	}
+
+	return args
+}
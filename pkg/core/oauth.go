@@ -0,0 +1,135 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/DODAS-TS/sts-wire/pkg/oidc"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+)
+
+// defaultScopes requested when no scope is configured.
+var defaultScopes = []string{"address", "phone", "openid", "email", "profile", "offline_access"} //nolint:gochecknoglobals
+
+// reservedParams cannot be overwritten by the extra parameters.
+var reservedParams = map[string]bool{ //nolint:gochecknoglobals
+	"client_id":             true,
+	"client_secret":         true,
+	"code":                  true,
+	"code_challenge":        true,
+	"code_challenge_method": true,
+	"code_verifier":         true,
+	"grant_type":            true,
+	"redirect_uri":          true,
+	"refresh_token":         true,
+	"response_type":         true,
+	"state":                 true,
+	"subject_token":         true,
+}
+
+// OAuthOptions are the scopes, the audience and the extra parameters added to
+// the client registration, authorization, refresh and token exchange requests.
+type OAuthOptions struct {
+	Scopes      []string
+	Audience    []string
+	ExtraParams map[string]string
+}
+
+// ScopeList returns the configured scopes or the default ones.
+func (o OAuthOptions) ScopeList() []string {
+	if len(o.Scopes) == 0 {
+		return defaultScopes
+	}
+
+	return o.Scopes
+}
+
+// Scope returns the scopes as a space separated string.
+func (o OAuthOptions) Scope() string {
+	return strings.Join(o.ScopeList(), " ")
+}
+
+// AuthCodeOptions returns the audience and the extra parameters of the
+// authorization request.
+func (o OAuthOptions) AuthCodeOptions() []oauth2.AuthCodeOption {
+	values := url.Values{}
+	o.setParams(values)
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	options := make([]oauth2.AuthCodeOption, 0, len(keys))
+	for _, key := range keys {
+		options = append(options, oauth2.SetAuthURLParam(key, values.Get(key)))
+	}
+
+	return options
+}
+
+// SetValues adds the scopes, the audience and the extra parameters to the
+// body of a token endpoint request. The scopes are added only if they were
+// configured, so that a refresh keeps the scopes of the original grant.
+func (o OAuthOptions) SetValues(values url.Values) {
+	if len(o.Scopes) != 0 {
+		values.Set("scope", o.Scope())
+	}
+
+	o.setParams(values)
+}
+
+// CheckSupported warns about the scopes not advertised by the IAM server.
+func (o OAuthOptions) CheckSupported(provider *oidc.ProviderMetadata) {
+	for _, scope := range o.ScopeList() {
+		if !provider.SupportsScope(scope) {
+			log.Warn().Str("scope", scope).Str("issuer", provider.Issuer).Msg("oauth - scope not supported by the IAM server")
+		}
+	}
+}
+
+func (o OAuthOptions) setParams(values url.Values) {
+	if len(o.Audience) != 0 {
+		values.Set("audience", strings.Join(o.Audience, " "))
+	}
+
+	for key, value := range o.ExtraParams {
+		if reservedParams[key] {
+			log.Warn().Str("param", key).Msg("oauth - reserved parameter ignored")
+
+			continue
+		}
+
+		values.Set(key, value)
+	}
+}
+
+// newTokenRequest returns a form encoded request to the token endpoint. The
+// confidential clients authenticate with client_secret_basic, so that the
+// secret is never sent in the URL or in the body.
+func newTokenRequest(ctx context.Context, tokenEndpoint string, values url.Values, client ClientResponse) (*http.Request, error) {
+	if client.ClientSecret == "" {
+		values.Set("client_id", client.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("token request %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if client.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(client.ClientID), url.QueryEscape(client.ClientSecret))
+	}
+
+	return req, nil
+}
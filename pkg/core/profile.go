@@ -0,0 +1,186 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	profilesFileName   = "profiles.yml"
+	profileDescription = "description"
+	profileBuiltin     = "built-in"
+)
+
+var (
+	errUnknownProfile    = errors.New("unknown profile")
+	errUnknownProfileKey = errors.New("unknown keys in profile")
+	// builtinProfiles are the settings of the well-known sites.
+	builtinProfiles = map[string]map[string]interface{}{ // nolint:gochecknoglobals
+		"infncloud": {
+			profileDescription: "INFN Cloud object storage",
+			"iamServer":        "https://iam.cloud.infn.it/",
+			"s3Endpoint":       "https://minio.cloud.infn.it/",
+			"stsAction":        STSActionWebIdentity,
+			"rcloneProvider":   "INFN Cloud",
+		},
+		"cnaf": {
+			profileDescription: "DODAS object storage at INFN CNAF",
+			"iamServer":        "https://dodas-iam.cloud.cnaf.infn.it/",
+			"s3Endpoint":       "https://minio.cloud.cnaf.infn.it/",
+			"stsAction":        STSActionWebIdentity,
+			"rcloneProvider":   "INFN Cloud",
+		},
+	}
+)
+
+// Profile bundles the settings of a site, e.g. the IAM server and the S3
+// endpoint, selected with --profile.
+type Profile struct {
+	Name        string
+	Description string
+	// Source is the profiles file or built-in
+	Source   string
+	Settings map[string]interface{}
+}
+
+// defaultProfilesFile returns the user profiles file in the sts-wire folder.
+func defaultProfilesFile() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		panic(err)
+	}
+
+	return filepath.Join(home, configDirName, profilesFileName)
+}
+
+// Profiles returns the built-in profiles and the profiles of the user file,
+// that replace the built-in ones with the same name.
+func Profiles() (map[string]Profile, error) {
+	profiles := map[string]Profile{}
+
+	for name, settings := range builtinProfiles {
+		profiles[name] = newProfile(name, profileBuiltin, settings)
+	}
+
+	filename := profilesFile
+	if filename == "" {
+		filename = defaultProfilesFile()
+
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			return profiles, nil
+		}
+	}
+
+	reader := viper.New()
+	reader.SetConfigFile(filename)
+
+	if err := reader.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("profiles file %s: %w", filename, err)
+	}
+
+	for name, value := range reader.AllSettings() {
+		settings, isMap := value.(map[string]interface{})
+		if !isMap {
+			return nil, fmt.Errorf("profiles file %s: %w: %s is not a map", filename, errNoValidConfigType, name)
+		}
+
+		profiles[name] = newProfile(name, filename, settings)
+	}
+
+	return profiles, nil
+}
+
+func newProfile(name string, source string, settings map[string]interface{}) Profile {
+	profile := Profile{
+		Name:     name,
+		Source:   source,
+		Settings: map[string]interface{}{},
+	}
+
+	for key, value := range settings {
+		if strings.EqualFold(key, profileDescription) {
+			profile.Description = fmt.Sprint(value)
+
+			continue
+		}
+
+		profile.Settings[strings.ToLower(key)] = value
+	}
+
+	return profile
+}
+
+// ProfileNames returns the sorted names of the profiles.
+func ProfileNames(profiles map[string]Profile) []string {
+	names := make([]string, 0, len(profiles))
+	for name := range profiles {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// profileName returns the selected profile: from the flag, the environment or
+// the config file.
+func profileName(flags *pflag.FlagSet, settings map[string]interface{}) string {
+	if flags != nil && flags.Changed("profile") {
+		return flags.Lookup("profile").Value.String()
+	}
+
+	if name := os.Getenv(envName("profile")); name != "" {
+		return name
+	}
+
+	if name, found := settings["profile"]; found {
+		return fmt.Sprint(name)
+	}
+
+	return ""
+}
+
+// loadProfile sets the values of the selected profile, if any.
+func (c *Config) loadProfile(flags *pflag.FlagSet, settings map[string]interface{}) error {
+	name := strings.ToLower(profileName(flags, settings))
+	if name == "" {
+		return nil
+	}
+
+	profiles, err := Profiles()
+	if err != nil {
+		return err
+	}
+
+	profile, found := profiles[name]
+	if !found {
+		return fmt.Errorf("%w: %s, available: %s", errUnknownProfile, name,
+			strings.Join(ProfileNames(profiles), ", "))
+	}
+
+	profileSettings := map[string]interface{}{}
+	for key, value := range profile.Settings {
+		profileSettings[key] = value
+	}
+
+	unknown, err := c.decode(profileSettings, sourceProfile+" "+name)
+	if err != nil {
+		return fmt.Errorf("profile %s: %w", name, err)
+	}
+
+	if len(unknown) != 0 {
+		return fmt.Errorf("%w %s: %s", errUnknownProfileKey, name, strings.Join(unknown, ", "))
+	}
+
+	c.Profile = name
+
+	return nil
+}
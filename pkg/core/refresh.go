@@ -0,0 +1,128 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// minRefreshInterval avoids to list the bucket continuously.
+	minRefreshInterval = 10 * time.Second
+	// refreshPollInterval is the time between two checks of the periodic
+	// refresh, so a reload of the interval is applied quickly.
+	refreshPollInterval = time.Second
+	refreshOK           = "OK"
+)
+
+var errNoValidRefreshDir = errors.New("the directory is outside of the mount point")
+
+// RefreshOptions of the periodic refresh of the directory cache, to see the
+// files uploaded by others before --dir-cache-time expires.
+type RefreshOptions struct {
+	Interval  time.Duration `mapstructure:"interval" yaml:"interval" desc:"time between two refreshes of the directory cache, 0 to disable them (min 10s)"`
+	Dirs      []string      `mapstructure:"dirs" yaml:"dirs" desc:"directories to refresh, relative to the root of the mount, empty for the root"`
+	Recursive bool          `mapstructure:"recursive" yaml:"recursive" desc:"refresh also the subdirectories"`
+}
+
+// Validate checks the interval.
+func (o RefreshOptions) Validate() error {
+	var errs validator.Errors
+
+	if o.Interval < 0 {
+		errs.Add("interval", errNoValidCacheDuration)
+	} else if o.Interval > 0 && o.Interval < minRefreshInterval {
+		errs.Add("interval", fmt.Errorf("%w: min %s", errNoValidConfigType, minRefreshInterval))
+	}
+
+	return errs.Err()
+}
+
+// refreshRequest refreshes a directory of the mount.
+type refreshRequest struct {
+	Dir       string `json:"dir"`
+	Recursive bool   `json:"recursive"`
+}
+
+// mountDir returns the directory relative to the root of the mount, empty for
+// the root. The absolute paths are inside the local mount point, the others
+// are relative to the root of the mount.
+func (l liveState) mountDir(dir string) (string, error) {
+	if filepath.IsAbs(dir) {
+		mountPoint, err := filepath.Abs(l.localPath)
+		if err != nil {
+			return "", fmt.Errorf("refresh %w", err)
+		}
+
+		rel, err := filepath.Rel(mountPoint, dir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("%w: %s", errNoValidRefreshDir, dir)
+		}
+
+		dir = rel
+	}
+
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(dir)), "/"), nil
+}
+
+// refreshDir forgets the cached listing of the directory and reads it again
+// from the bucket, with the subdirectories if recursive. It returns the
+// result of rclone by directory, OK if refreshed.
+func (l liveState) refreshDir(dir string, recursive bool) (map[string]string, error) {
+	dir, err := l.mountDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	dirs := []string{}
+	if dir != "" {
+		dirs = append(dirs, dir)
+	}
+
+	if _, err := l.rc.VFSForget(ctx, nil, dirs); err != nil {
+		return nil, fmt.Errorf("refresh %w", err)
+	}
+
+	result, err := l.rc.VFSRefresh(ctx, recursive, dirs...)
+	if err != nil {
+		return nil, fmt.Errorf("refresh %w", err)
+	}
+
+	log.Debug().Str("dir", dir).Bool("recursive", recursive).Interface("result", result).Msg("refresh")
+
+	return result, nil
+}
+
+// refreshDirs refreshes the directories of the periodic refresh.
+func (l liveState) refreshDirs() {
+	options := l.refresh
+
+	dirs := options.Dirs
+	if len(dirs) == 0 {
+		dirs = []string{""}
+	}
+
+	for _, dir := range dirs {
+		result, err := l.refreshDir(dir, options.Recursive)
+		if err != nil {
+			log.Warn().Err(err).Str("dir", dir).Msg("refresh - periodic")
+
+			continue
+		}
+
+		for name, status := range result {
+			if status != refreshOK {
+				log.Warn().Str("dir", name).Str("result", status).Msg("refresh - periodic")
+			}
+		}
+	}
+}
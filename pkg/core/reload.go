@@ -0,0 +1,246 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gookit/color"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	errNoReload = errors.New("the configuration cannot be reloaded")
+	// liveKeys are applied without touching the mount.
+	liveKeys = map[string]bool{ // nolint:gochecknoglobals
+		"refreshTokenRenew":   true,
+		"logLevel":            true,
+		"noDummyFileCheck":    true,
+		"tryRemount":          true,
+		"healthCheckInterval": true,
+		"shutdownTimeout":     true,
+		"unmountStale":        true,
+		"bandwidth.limit":     true,
+		"bandwidth.upload":    true,
+		"bandwidth.download":  true,
+		"refresh.interval":    true,
+		"refresh.dirs":        true,
+		"refresh.recursive":   true,
+	}
+	// remountKeys are applied mounting again the volume, keeping the IAM session.
+	remountKeys = map[string]bool{ // nolint:gochecknoglobals
+		"rcloneRemotePath":  true,
+		"readOnly":          true,
+		"noModtime":         true,
+		"localCache":        true,
+		"localCacheDir":     true,
+		"rcloneMountFlags":  true,
+		"rcloneProvider":    true,
+		"insecureConn":      true,
+		"caFile":            true,
+		"caDir":             true,
+		"clientCert":        true,
+		"clientKey":         true,
+		"bandwidth.perFile": true,
+		"allowNonEmpty":     true,
+	}
+	// remountSections are the sections whose keys are applied with a remount.
+	remountSections = []string{"cache.", "proxy.", "network."} // nolint:gochecknoglobals
+)
+
+// ReloadResult reports the changes of a configuration reload.
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	Remounted       bool     `json:"remounted"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+func needsRemount(key string) bool {
+	if remountKeys[key] {
+		return true
+	}
+
+	for _, section := range remountSections {
+		if strings.HasPrefix(key, section) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sameValue compares two values of the configuration. Empty lists and maps
+// are equal to the missing ones.
+func sameValue(a reflect.Value, b reflect.Value) bool {
+	switch a.Kind() { // nolint:exhaustive
+	case reflect.Slice, reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	}
+
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// setLogLevel sets the global log level.
+func setLogLevel(level string) {
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil {
+		log.Warn().Err(err).Str("logLevel", level).Msg("reload - log level")
+
+		return
+	}
+
+	zerolog.SetGlobalLevel(parsed)
+}
+
+// reload reads again the configuration and applies the changes: the renewal
+// time, the log level, the health check, the shutdown settings, the
+// periodic refresh and the bandwidth limit are applied live, the mount
+// options and the bandwidth timetables mounting again the volume with the
+// current credentials. The other changes, e.g. the IAM server, require a
+// restart and are ignored. A failed reload applies nothing: after a failed
+// remount the volume is mounted again with the running options.
+func (s *Server) reload(trigger string) (ReloadResult, error) { // nolint:funlen
+	result := ReloadResult{Applied: []string{}, RestartRequired: []string{}} // nolint:exhaustivestruct
+
+	log.Debug().Str("trigger", trigger).Msg("reload")
+
+	if s.reloadConfig == nil {
+		return result, errNoReload
+	}
+
+	cfg, err := s.reloadConfig()
+	if err == nil {
+		err = cfg.Validate()
+	}
+
+	if err != nil {
+		log.Err(err).Str("trigger", trigger).Msg("reload")
+		color.Red.Printf("==> Configuration not reloaded (%s):\n", trigger)
+		printConfigErrors(err)
+
+		return result, fmt.Errorf("reload %w", err)
+	}
+
+	remount := false
+	curFields := s.config.fields()
+	newFields := cfg.fields()
+	// rclone changes a timetable only at the mount
+	bwTimetable := s.Bandwidth.Timetable() || cfg.Bandwidth.Timetable()
+
+	for idx, field := range newFields {
+		if sameValue(curFields[idx].value, field.value) {
+			continue
+		}
+
+		switch {
+		case field.key == "bandwidth.limit" && bwTimetable:
+			result.Applied = append(result.Applied, field.key)
+			remount = true
+		case liveKeys[field.key]:
+			result.Applied = append(result.Applied, field.key)
+		case needsRemount(field.key):
+			result.Applied = append(result.Applied, field.key)
+			remount = true
+		default:
+			result.RestartRequired = append(result.RestartRequired, field.key)
+			// keep the running value, the change is reported at each reload
+			field.value.Set(curFields[idx].value)
+		}
+	}
+
+	if remount {
+		color.Yellow.Println("==> Mount options changed, mounting again the volume...")
+
+		if errApply := s.applyMountConfig(cfg); errApply != nil {
+			s.restoreMountConfig(false)
+
+			return result, fmt.Errorf("reload %w", errApply)
+		}
+
+		if errRemount := s.remount(); errRemount != nil {
+			s.restoreMountConfig(true)
+
+			return result, fmt.Errorf("reload %w", errRemount)
+		}
+
+		result.Remounted = true
+	} else if s.Bandwidth.Rate() != cfg.Bandwidth.Rate() {
+		rate := cfg.Bandwidth.Rate()
+		if rate == "" {
+			rate = "off"
+		}
+
+		if _, errLimit := s.state().setBwLimit(rate); errLimit != nil {
+			return result, fmt.Errorf("reload %w", errLimit)
+		}
+	}
+
+	// the live keys are applied only when the reload succeeds, so they match
+	// the running configuration
+	s.RefreshTokenRenew = cfg.RefreshTokenRenew
+	s.NoDummyFileCheck = cfg.NoDummyFileCheck
+	s.TryRemount = cfg.TryRemount
+	s.HealthCheckInterval = cfg.HealthCheckInterval
+	s.ShutdownTimeout = cfg.ShutdownTimeout
+	s.Refresh = cfg.Refresh
+	s.Bandwidth = cfg.Bandwidth
+	setLogLevel(cfg.LogLevel)
+
+	s.config = cfg
+
+	log.Info().Str("trigger", trigger).Strs("applied", result.Applied).Bool("remounted",
+		result.Remounted).Strs("restartRequired", result.RestartRequired).Msg("reload")
+
+	color.Green.Printf("==> Configuration reloaded (%s), changes applied: %d\n", trigger, len(result.Applied))
+
+	if len(result.RestartRequired) != 0 {
+		color.Yellow.Printf("==> Restart sts-wire to apply: %s\n", strings.Join(result.RestartRequired, ", "))
+	}
+
+	return result, nil
+}
+
+// applyMountConfig sets the mount options of the configuration and writes the
+// rclone config, they are used by rclone at the next mount.
+func (s *Server) applyMountConfig(cfg Config) error {
+	s.RemotePath = cfg.RcloneRemotePath
+	s.ReadOnly = cfg.ReadOnly
+	s.NoModtime = cfg.NoModtime
+	s.AllowNonEmpty = cfg.AllowNonEmpty
+	s.LocalCache = cfg.LocalCache
+	s.LocalCacheDir = cfg.LocalCacheDir
+	s.Cache = cfg.Cache
+	s.Bandwidth = cfg.Bandwidth
+	s.MountOptions = cfg.MountOptions()
+	s.RcloneProvider = cfg.RcloneProvider
+	s.TLS = cfg.TLSConfig()
+	s.Network = cfg.NetworkConfig()
+
+	httpClient, err := newHTTPClient(s.TLS, s.Network)
+	if err != nil {
+		return err
+	}
+
+	s.Client.HTTPClient = *httpClient
+
+	return s.writeRcloneConfig()
+}
+
+// restoreMountConfig goes back to the mount options of the running
+// configuration after a failed reload, mounting again the volume with them if
+// rclone was stopped.
+func (s *Server) restoreMountConfig(remount bool) {
+	err := s.applyMountConfig(s.config)
+	if err == nil && remount {
+		err = s.remount()
+	}
+
+	if err != nil {
+		log.Err(err).Msg("reload - restore")
+		color.Red.Printf("==> Cannot restore the previous mount options: %s\n", err)
+	}
+}
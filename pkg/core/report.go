@@ -8,12 +8,10 @@ import (
 	"strings"
 	"time"
 
+	"github.com/DODAS-TS/sts-wire/pkg/redact"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"github.com/shirou/gopsutil/host"
-
-	"github.com/spf13/viper"
-	yaml "gopkg.in/yaml.v2"
 )
 
 func instanceLog() string {
@@ -39,13 +37,18 @@ func instanceLog() string {
 }
 
 func WriteReport(mainErr interface{}) {
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
+	log.Logger = log.Output(redact.NewWriter(zerolog.ConsoleWriter{Out: os.Stderr})) // nolint:exhaustivestruct
 
 	report := strings.Builder{}
 
 	hostStat, _ := host.Info()
 
 	report.WriteString(divider)
+
+	if !redact.Enabled() {
+		report.WriteString("\n| WARNING: secrets are not redacted (--unsafeLogSecrets), do not share this report!")
+	}
+
 	report.WriteString("\n| Version\n")
 	report.WriteString(buildCmdVersion())
 	report.WriteRune('\n')
@@ -65,9 +68,7 @@ func WriteReport(mainErr interface{}) {
 	report.WriteString(divider)
 	report.WriteRune('\n')
 
-	c := viper.AllSettings()
-
-	bs, errMarshall := yaml.Marshal(c)
+	bs, errMarshall := currentConfig.YAML(false, true)
 	if errMarshall != nil {
 		log.Err(errMarshall).Msg("unable to marshal config to YAML")
 	}
@@ -81,14 +82,14 @@ func WriteReport(mainErr interface{}) {
 	report.WriteString("| Instance log\n")
 	report.WriteString(divider)
 	report.WriteRune('\n')
-	report.WriteString(instanceLog())
+	report.WriteString(redact.String(instanceLog()))
 	report.WriteRune('\n')
 
 	report.WriteString(divider)
 	report.WriteString("\n| Error\n")
 	report.WriteString(divider)
 	report.WriteRune('\n')
-	report.WriteString(fmt.Sprintf("%s\n", mainErr))
+	report.WriteString(redact.String(fmt.Sprintf("%s\n", mainErr)))
 
 	baseDir := filepath.Clean(filepath.Dir(instanceLogFilename))
 
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	requestTimeout     = 30 * time.Second
+	maxRequestAttempts = 4
+	retryBaseDelay     = 500 * time.Millisecond
+	retryMaxDelay      = 8 * time.Second
+	maxResponseSize    = 1 << 20
+)
+
+var errRetryStatus = errors.New("server error")
+
+// requestFunc builds a new request for each attempt, so that the body can be
+// sent again.
+type requestFunc func(ctx context.Context) (*http.Request, error)
+
+// doWithRetry sends the request and reads the response body. Each attempt has
+// its own deadline and the temporary network errors and the server errors
+// (5xx and 429) are retried with an exponential backoff. A request that is
+// not idempotent, e.g. the refresh token grant of an IAM that rotates the
+// refresh tokens, is retried only if the server refused it with 429: after a
+// timeout or a 5xx it could have been executed. The last response is returned
+// even if it has an error status, so that the caller can decode the error.
+func doWithRetry(client *http.Client, name string, idempotent bool,
+	newRequest requestFunc) (*http.Response, []byte, error) {
+	var lastErr error
+
+	for attempt := 1; attempt <= maxRequestAttempts; attempt++ {
+		resp, body, err := doRequest(client, newRequest)
+
+		switch {
+		case err != nil && (!idempotent || !retryableError(err)):
+			return nil, nil, err
+		case err != nil:
+			lastErr = err
+		case retryableStatus(resp.StatusCode, idempotent) && attempt < maxRequestAttempts:
+			lastErr = fmt.Errorf("%w: %s", errRetryStatus, resp.Status)
+		default:
+			return resp, body, nil
+		}
+
+		if attempt == maxRequestAttempts {
+			break
+		}
+
+		delay := retryDelay(attempt)
+
+		log.Warn().Err(lastErr).Str("request", name).Int("attempt", attempt).Dur("retryIn",
+			delay).Msg("request failed")
+
+		time.Sleep(delay)
+	}
+
+	return nil, nil, fmt.Errorf("%s failed after %d attempts: %w", name, maxRequestAttempts, lastErr)
+}
+
+func doRequest(client *http.Client, newRequest requestFunc) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	req, err := newRequest(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("request %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	var body bytes.Buffer
+
+	if _, err := body.ReadFrom(http.MaxBytesReader(nil, resp.Body, maxResponseSize)); err != nil {
+		return nil, nil, fmt.Errorf("read response %w", err)
+	}
+
+	return resp, body.Bytes(), nil
+}
+
+// retryableError reports if the error is a temporary network error: a
+// timeout, also of the attempt deadline, a connection reset or a response
+// cut short. The unknown hosts, the refused connections, the TLS and the
+// certificate errors are not retried.
+func retryableError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+
+	var netErr net.Error
+
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// retryableStatus reports if the status is a temporary error of the server,
+// only the rate limit for the requests that are not idempotent.
+func retryableStatus(statusCode int, idempotent bool) bool {
+	if statusCode == http.StatusTooManyRequests {
+		return true
+	}
+
+	return idempotent && statusCode >= http.StatusInternalServerError
+}
+
+// retryDelay is an exponential backoff with jitter.
+func retryDelay(attempt int) time.Duration {
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2))) // nolint:gosec
+}
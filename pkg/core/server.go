@@ -3,7 +3,9 @@ package core
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,9 +19,12 @@ import (
 	"reflect"
 	"strings"
 	"sync"
+	"syscall"
 	"text/template"
 	"time"
 
+	"github.com/DODAS-TS/sts-wire/pkg/oidc"
+	"github.com/DODAS-TS/sts-wire/pkg/rclone"
 	iamTmpl "github.com/DODAS-TS/sts-wire/pkg/template"
 	"github.com/gookit/color"
 	"github.com/minio/minio-go/v6/pkg/credentials"
@@ -33,12 +38,19 @@ const (
 	deltaCheckTokenRefresh  = time.Duration(30 * time.Second)
 	checkRuntimeRcloneSleep = 60 * time.Second
 	maxRemountAttempts      = 10
+	tokenVerifyTimeout      = 30 * time.Second
+	// credentialsRetryInterval is the time before trying again a failed
+	// renewal of the credentials.
+	credentialsRetryInterval = 1 * time.Minute
 )
 
 var (
 	errNoClientID     = errors.New("no ClientID available")
 	errNoClientSecret = errors.New("no Client Secret available")
 	errNoRefreshToken = errors.New("no Refresh Token available")
+	// errInvalidAccessToken is returned when the IAM server answers the
+	// refresh without an access token.
+	errInvalidAccessToken = errors.New("invalid access token")
 )
 
 func availableRandomPort() (port string, err error) {
@@ -64,8 +76,12 @@ func availableRandomPort() (port string, err error) {
 
 // RCloneStruct ..
 type RCloneStruct struct {
-	Address  string
-	Instance string
+	Address         string
+	Instance        string
+	Provider        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
 }
 
 // IAMCreds ..
@@ -93,16 +109,147 @@ type Server struct {
 	LocalCache        string
 	LocalCacheDir     string
 	ReadOnly          bool
-	MountNewFlags     string
+	Cache             CacheOptions
+	Bandwidth         BandwidthOptions
+	Refresh           RefreshOptions
+	MountOptions      rclone.MountOptions
+	RcloneProvider    string
 	TryRemount        bool
-	numRemount        int
+	AllowNonEmpty     bool
+	// HealthCheckInterval between two checks of the mount point
+	HealthCheckInterval time.Duration
+	ShutdownTimeout     time.Duration
+	RedirectURL         string
+	CallbackPath        string
+	OAuth               OAuthOptions
+	TokenExchange       TokenExchangeConfig
+	STS                 STSConfig
+	TLS                 TLSConfig
+	Network             NetworkConfig
+	stsCreds            credentials.Value
+	stsExpiration       time.Time
+	packedPolicySize    int
+	PublicClient        bool
+	Provider            *oidc.ProviderMetadata
+	NoTokenVerify       bool
+	tokenVerifier       *oidc.Verifier
+	numRemount          int
+	// config is the running configuration and reloadConfig reads it again
+	config       Config
+	reloadConfig func() (Config, error)
+	reloadChan   chan chan reloadResponse
+	// rc is the remote control API of the running rclone
+	rc rclone.RC
+	// mu guards the state changed by the token loop, which holds it to write,
+	// from the control API and the background checks, which hold it only to
+	// copy it with readState
+	mu sync.RWMutex
+}
+
+// liveState is a copy of the state changed by the token loop, used by the
+// control API and the background checks for the slow calls to rclone and to
+// the mount point without blocking the loop.
+type liveState struct {
+	instance            string
+	localPath           string
+	remotePath          string
+	mountSource         string
+	configFile          string
+	readOnly            bool
+	noDummyFileCheck    bool
+	healthCheckInterval time.Duration
+	refresh             RefreshOptions
+	rc                  rclone.RC
+	rcloneLogPath       string
+	rcloneProcess       *os.Process
+}
+
+// state copies the state, the caller is the token loop or holds the lock.
+func (s *Server) state() liveState {
+	state := liveState{
+		instance:            s.Instance,
+		localPath:           s.LocalPath,
+		remotePath:          s.RemotePath,
+		mountSource:         s.mountSource(),
+		configFile:          s.config.File(),
+		readOnly:            s.ReadOnly,
+		noDummyFileCheck:    s.NoDummyFileCheck,
+		healthCheckInterval: s.healthCheckInterval(),
+		refresh:             s.Refresh,
+		rc:                  s.rc,
+		rcloneLogPath:       s.rcloneLogPath,
+		rcloneProcess:       nil,
+	}
+
+	if s.rcloneCmd != nil {
+		state.rcloneProcess = s.rcloneCmd.Process
+	}
+
+	return state
+}
+
+// readState copies the state holding the lock to read, for the goroutines
+// other than the token loop.
+func (s *Server) readState() liveState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state()
+}
+
+// provider returns the OIDC metadata of the IAM server, falling back to
+// the default INDIGO IAM endpoints when it was not discovered.
+func (s *Server) provider(endpoint string) *oidc.ProviderMetadata {
+	if s.Provider == nil {
+		s.Provider = oidc.Fallback(endpoint)
+	}
+
+	return s.Provider
+}
+
+// verifyToken checks the signature and the claims of the access token
+// with the keys published by the IAM server.
+func (s *Server) verifyToken(token string) error {
+	if s.NoTokenVerify {
+		log.Debug().Msg("server - token verification disabled")
+
+		return nil
+	}
+
+	if s.tokenVerifier == nil {
+		provider := s.provider(s.Endpoint)
+		keySet := oidc.NewKeySet(&s.Client.HTTPClient, provider.JwksURI)
+		s.tokenVerifier = oidc.NewVerifier(provider, keySet, s.tokenAudience())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tokenVerifyTimeout)
+	defer cancel()
+
+	claims, err := s.tokenVerifier.Verify(ctx, token)
+	if err != nil {
+		return fmt.Errorf("token verification %w", err)
+	}
+
+	log.Debug().Str("subject", claims.Subject).Strs("audience",
+		claims.Audience).Time("expiry", claims.Expiry()).Msg("server - token verified")
+
+	return nil
+}
+
+// tokenAudience returns the audience expected in the token sent to the STS.
+func (s *Server) tokenAudience() []string {
+	if s.TokenExchange.Enabled && len(s.TokenExchange.Audience) != 0 {
+		return s.TokenExchange.Audience
+	}
+
+	return s.OAuth.Audience
 }
 
 func (s *Server) noRefreshToken() IAMCreds {
 	state := RandomState()
 	credsIAM := IAMCreds{}
 
-	endpoint := s.Endpoint
+	provider := s.provider(s.Endpoint)
 
 	sigint := make(chan int, 1)
 
@@ -112,6 +259,9 @@ func (s *Server) noRefreshToken() IAMCreds {
 	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
 	defer cancel()
 
+	// The code exchange uses the same TLS settings of the other requests
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &s.Client.HTTPClient)
+
 	go func() {
 		select {
 		case <-ctx.Done():
@@ -124,15 +274,45 @@ func (s *Server) noRefreshToken() IAMCreds {
 		}
 	}()
 
+	callbackPath := s.CallbackPath
+	if callbackPath == "" {
+		callbackPath = defaultCallbackPath
+	}
+
+	redirectURL := s.RedirectURL
+	if redirectURL == "" {
+		redirectURL = fmt.Sprintf("http://localhost:%d%s", s.Client.ClientConfig.Port, callbackPath)
+	}
+
 	config := oauth2.Config{
 		ClientID:     s.CurClientResponse.ClientID,
 		ClientSecret: s.CurClientResponse.ClientSecret,
 		Endpoint: oauth2.Endpoint{ // nolint:exhaustivestruct
-			AuthURL:  endpoint + "/authorize",
-			TokenURL: endpoint + "/token",
+			AuthURL:  provider.AuthorizationEndpoint,
+			TokenURL: provider.TokenEndpoint,
 		},
-		RedirectURL: fmt.Sprintf("http://localhost:%d/oauth2/callback", s.Client.ClientConfig.Port),
-		Scopes:      []string{"address", "phone", "openid", "email", "profile", "offline_access"},
+		RedirectURL: redirectURL,
+		Scopes:      s.OAuth.ScopeList(),
+	}
+
+	var (
+		authCodeOpts = s.OAuth.AuthCodeOptions()
+		exchangeOpts []oauth2.AuthCodeOption
+	)
+
+	if s.PublicClient {
+		// Public clients have no secret: send the client id in the body
+		// and protect the authorization code with PKCE (RFC 7636).
+		config.Endpoint.AuthStyle = oauth2.AuthStyleInParams
+
+		codeVerifier := RandomState()
+		codeChallenge := sha256.Sum256([]byte(codeVerifier))
+
+		authCodeOpts = append(authCodeOpts,
+			oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(codeChallenge[:])),
+			oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+		)
+		exchangeOpts = append(exchangeOpts, oauth2.SetAuthURLParam("code_verifier", codeVerifier))
 	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -144,11 +324,11 @@ func (s *Server) noRefreshToken() IAMCreds {
 			return
 		}
 
-		http.Redirect(w, r, config.AuthCodeURL(state), http.StatusFound)
+		http.Redirect(w, r, config.AuthCodeURL(state, authCodeOpts...), http.StatusFound)
 	})
 
-	http.HandleFunc("/oauth2/callback", func(w http.ResponseWriter, r *http.Request) {
-		log.Debug().Str("method", r.Method).Str("URI", r.RequestURI).Msg("IAM Client - /oauth2/callback")
+	http.HandleFunc(callbackPath, func(w http.ResponseWriter, r *http.Request) {
+		log.Debug().Str("method", r.Method).Str("URI", r.RequestURI).Msg("IAM Client - " + callbackPath)
 
 		if r.URL.Query().Get("state") != state {
 			http.Error(w, "state did not match", http.StatusBadRequest)
@@ -156,7 +336,7 @@ func (s *Server) noRefreshToken() IAMCreds {
 			return
 		}
 
-		oauth2Token, err := config.Exchange(ctx, r.URL.Query().Get("code"))
+		oauth2Token, err := config.Exchange(ctx, r.URL.Query().Get("code"), exchangeOpts...)
 		if err != nil {
 			log.Err(err).Str("error", "cannot get token with OAuth").Msg("server - OAuth")
 
@@ -186,7 +366,22 @@ func (s *Server) noRefreshToken() IAMCreds {
 			return
 		}
 
-		token := oauth2Token.Extra("access_token").(string)
+		token, errToken := s.storageToken(oauth2Token.Extra("access_token").(string))
+		if errToken != nil {
+			log.Err(errToken).Msg("server - OAuth")
+			color.Red.Printf("==> The access token is not valid: %s\n", errToken)
+
+			w.WriteHeader(http.StatusBadRequest)
+
+			_, errWrite := w.Write(htmlErrorInvalidToken)
+			if errWrite != nil {
+				panic(errWrite)
+			}
+
+			sigint <- -1
+
+			return
+		}
 
 		credsIAM.AccessToken = token
 		credsIAM.RefreshToken = oauth2Token.Extra("refresh_token").(string)
@@ -223,26 +418,14 @@ func (s *Server) noRefreshToken() IAMCreds {
 
 		//fmt.Println(token)
 
-		//sts, err := credentials.NewSTSWebIdentity("https://131.154.97.121:9001/", getWebTokenExpiry)
-		providers := []credentials.Provider{
-			&IAMProvider{ //nolint: exhaustivestruct
-				StsEndpoint:       s.S3Endpoint,
-				Token:             token,
-				HTTPClient:        &s.Client.HTTPClient,
-				RefreshTokenRenew: s.RefreshTokenRenew,
-			},
-		}
-
-		sts := credentials.NewChainCredentials(providers)
-
-		creds, errSts := sts.Get()
+		creds, errSts := s.stsCredentials(token)
 		if errSts != nil {
 			log.Err(fmt.Errorf("could not get STS credentials: %w",
 				errSts)).Msg("server - OAuth")
 
 			w.WriteHeader(http.StatusBadRequest)
 
-			_, errWrite := w.Write(htmlErrorNoStsCred)
+			_, errWrite := w.Write(stsErrorPage(errSts))
 			if errWrite != nil {
 				panic(errWrite)
 			}
@@ -252,7 +435,7 @@ func (s *Server) noRefreshToken() IAMCreds {
 			return
 		}
 
-		log.Debug().Str("creds", fmt.Sprintf("%+v", creds)).Msg("server")
+		log.Debug().Str("accessKeyID", creds.AccessKeyID).Msg("server - STS credentials acquired")
 
 		response := make(map[string]interface{})
 		response["credentials"] = creds
@@ -291,12 +474,12 @@ func (s *Server) noRefreshToken() IAMCreds {
 	err := browser.OpenURL(urlBrowse)
 	if err != nil {
 		log.Err(err).Msg("Failed to open browser, trying to copy the following on you browser")
-		log.Debug().Msg(config.AuthCodeURL(state))
+		log.Debug().Msg(config.AuthCodeURL(state, authCodeOpts...))
 		log.Debug().Msg("After that copy the resulting address and run the following command on a separate shell")
 		log.Debug().Msg("curl <your resulting address> -> e.g. \"http://localhost:3128/oauth2/callback?code=1tpAd&state=9RpeJxIf\"")
 
 		color.Red.Println("!!! Failed to open browser, trying to copy the following on you browser")
-		fmt.Printf("==> %s\n", config.AuthCodeURL(state))
+		fmt.Printf("==> %s\n", config.AuthCodeURL(state, authCodeOpts...))
 		color.Yellow.Println("=> After that copy the resulting address and run the following command on a separate shell")
 		color.Yellow.Println("-> curl <your resulting address> -> e.g. \"http://localhost:3128/oauth2/callback?code=1tpAd&state=9RpeJxIf\"")
 	}
@@ -344,9 +527,20 @@ func (s *Server) useRefreshToken() IAMCreds {
 		RefreshToken: refreshToken,
 	}
 
-	log.Debug().Str("refreshToken",
-		refreshToken).Str("accessToken",
-		accessToken).Msg("Writing down access token")
+	log.Debug().Bool("refreshToken",
+		refreshToken != "").Bool("accessToken",
+		accessToken != "").Msg("Writing down access token")
+
+	if accessToken != "" {
+		storageToken, err := s.storageToken(accessToken)
+		if err != nil {
+			color.Red.Printf("==> The access token is not valid: %s\n", err)
+			panic(err)
+		}
+
+		accessToken = storageToken
+		credsIAM.AccessToken = storageToken
+	}
 
 	// cwd, _ := os.Getwd()
 	// fmt.Printf("\nWORKING DIR %s\n", cwd)
@@ -371,19 +565,7 @@ func (s *Server) useRefreshToken() IAMCreds {
 		panic(err)
 	}
 
-	//sts, err := credentials.NewSTSWebIdentity("https://131.154.97.121:9001/", getWebTokenExpiry)
-	providers := []credentials.Provider{
-		&IAMProvider{ // nolint:exhaustivestruct
-			StsEndpoint:       s.S3Endpoint,
-			Token:             accessToken,
-			HTTPClient:        &s.Client.HTTPClient,
-			RefreshTokenRenew: s.RefreshTokenRenew,
-		},
-	}
-
-	sts := credentials.NewChainCredentials(providers)
-
-	creds, err := sts.Get()
+	creds, err := s.stsCredentials(accessToken)
 	if err != nil {
 		log.Err(fmt.Errorf("Could not get STS credentials: %s", err)).Msg("server")
 		panic(err)
@@ -407,18 +589,78 @@ func (s *Server) useRefreshToken() IAMCreds {
 func (s *Server) Start() (IAMCreds, string, error) { //nolint: funlen, gocognit
 	var credsIAM IAMCreds
 
-	if os.Getenv("REFRESH_TOKEN") == "" { //nolint:nestif
+	switch {
+	case !s.STS.NeedsToken():
+		credsIAM = s.noTokenLogin()
+	case s.STS.Action == STSActionClientGrants:
+		credsIAM = s.clientGrantsLogin()
+	case os.Getenv("REFRESH_TOKEN") == "":
 		credsIAM = s.noRefreshToken()
-	} else {
+	default:
 		credsIAM = s.useRefreshToken()
 	}
 
 	log.Debug().Str("s.S3Endpoint", s.S3Endpoint).Msg("server")
 	log.Debug().Str("s.Instance", s.Instance).Msg("server")
 
-	confRClone := RCloneStruct{
+	if err := s.writeRcloneConfig(); err != nil {
+		panic(err)
+	}
+
+	rcloneCmd, errChan, logPath, errMount := MountVolume(s)
+	if errMount != nil {
+		panic(errMount)
+	}
+
+	s.rcloneCmd = rcloneCmd
+	s.rcloneErrChan = errChan
+	s.rcloneLogPath = logPath
+
+	log.Debug().Str("Mounted on", s.LocalPath).Msg("Server")
+	color.Green.Printf("==> Volume mounted at %s\n", s.LocalPath)
+
+	// // TODO: start routine to keep token valid!
+	// cntxt := &daemon.Context{
+	// 	PidFileName: "mount.pid",
+	// 	PidFilePerm: 0644,
+	// 	LogFileName: "mount.log",
+	// 	LogFilePerm: 0640,
+	// 	WorkDir:     "./",
+	// }
+
+	// d, err := cntxt.Reborn()
+	// if err != nil {
+	// 	return err
+	// }
+	// if d != nil {
+	// 	return fmt.Errorf("Process exists")
+	// }
+	// defer cntxt.Release()
+
+	// log.Print("- - - - - - - - - - - - - - -")
+	// log.Print("daemon started")
+	return credsIAM, s.Endpoint, nil
+}
+
+// writeRcloneConfig writes the rclone remote of the instance. With the static
+// credentials the keys obtained from the STS are written in the remote,
+// otherwise rclone asks them to the STS with the token file.
+func (s *Server) writeRcloneConfig() error {
+	confRClone := RCloneStruct{ // nolint:exhaustivestruct
 		Address:  s.S3Endpoint,
 		Instance: s.Instance,
+		Provider: "INFN Cloud",
+	}
+
+	if s.STS.StaticCredentials() {
+		confRClone.Provider = "Minio"
+		confRClone.AccessKeyID = s.stsCreds.AccessKeyID
+		confRClone.SecretAccessKey = s.stsCreds.SecretAccessKey
+		confRClone.SessionToken = s.stsCreds.SessionToken
+	}
+
+	if s.RcloneProvider != "" {
+		confRClone.Provider = s.RcloneProvider
 	}
 
 	tmpl, err := template.New("client").Parse(iamTmpl.RCloneTemplate)
@@ -434,135 +676,129 @@ func (s *Server) Start() (IAMCreds, string, error) { //nolint: funlen, gocognit
 	}
 
 	rclone := b.String()
-	log.Debug().Str("rclone config", rclone).Msg("server")
+	log.Debug().Str("provider", confRClone.Provider).Str("accessKeyID",
+		confRClone.AccessKeyID).Msg("server - rclone config")
 
 	filename := s.Client.ConfDir + "/" + "rclone.conf"
 
 	curFile, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		log.Err(err).Msg("server - rclone conf file")
+
+		return fmt.Errorf("rclone conf file %w", err)
 	}
 
+	defer curFile.Close()
+
 	_, err = curFile.Write([]byte(rclone))
 	if err != nil {
 		log.Err(err).Msg("server - rclone conf file")
+
+		return fmt.Errorf("rclone conf file %w", err)
 	}
 
-	err = curFile.Close()
-	if err != nil {
-		log.Err(err).Msg("server - rclone conf file")
+	return nil
+}
+
+// remount stops rclone and mounts again the volume with the current
+// configuration. It does not wait for the uploads: the files not uploaded are
+// kept in the cache folder and rclone resumes them at the mount.
+func (s *Server) remount() error {
+	log.Debug().Msg("server - remount")
+
+	if err := s.stopRclone(); err != nil {
+		return fmt.Errorf("remount %w", err)
 	}
 
-	if err != nil {
-		panic(err)
+	// Wait for the exit of rclone
+	for range s.rcloneErrChan { // nolint:revive
+	}
+
+	if err := unmount(s.LocalPath); err != nil {
+		return fmt.Errorf("remount %w", err)
 	}
 
 	rcloneCmd, errChan, logPath, errMount := MountVolume(s)
 	if errMount != nil {
-		panic(errMount)
+		return fmt.Errorf("remount %w", errMount)
 	}
 
 	s.rcloneCmd = rcloneCmd
 	s.rcloneErrChan = errChan
 	s.rcloneLogPath = logPath
 
-	log.Debug().Str("Mounted on", s.LocalPath).Msg("Server")
-	color.Green.Printf("==> Volume mounted at %s\n", s.LocalPath)
+	return nil
+}
 
-	// // TODO: start routine to keep token valid!
-	// cntxt := &daemon.Context{
-	// 	PidFileName: "mount.pid",
-	// 	PidFilePerm: 0644,
-	// 	LogFileName: "mount.log",
-	// 	LogFilePerm: 0640,
-	// 	WorkDir:     "./",
-	// }
+// RefreshToken renews the access token and, if they are expiring, the STS
+// credentials used by rclone. The tokens of credsIAM are replaced by the
+// renewed ones, since the IAM server can rotate the refresh token. A failed
+// renewal of the credentials is returned.
+func (s *Server) RefreshToken(credsIAM *IAMCreds, endpoint string) error { //nolint:funlen
+	switch {
+	case !s.STS.NeedsToken():
+		return s.renewCredentials("")
+	case s.STS.Action == STSActionClientGrants:
+		token, err := s.clientCredentialsToken()
+		if err != nil {
+			return fmt.Errorf("refresh token %w", err)
+		}
 
-	// d, err := cntxt.Reborn()
-	// if err != nil {
-	// 	return err
-	// }
-	// if d != nil {
-	// 	return fmt.Errorf("Process exists")
-	// }
-	// defer cntxt.Release()
+		if err := writeTokenFile(token); err != nil {
+			return fmt.Errorf("refresh token %w", err)
+		}
 
-	// log.Print("- - - - - - - - - - - - - - -")
-	// log.Print("daemon started")
-	return credsIAM, s.Endpoint, nil
-}
+		return s.renewCredentials(token)
+	}
 
-func (s *Server) RefreshToken(credsIAM IAMCreds, endpoint string) { //nolint:funlen
 	v := url.Values{}
 
 	log.Debug().Str("client_id",
-		s.CurClientResponse.ClientID).Str("client_secret",
-		s.CurClientResponse.ClientSecret).Str("grant_type",
-		"refresh_token").Str("refresh_token",
-		credsIAM.RefreshToken).Msg("Refresh token")
+		s.CurClientResponse.ClientID).Bool("public_client",
+		s.PublicClient).Str("grant_type",
+		"refresh_token").Msg("Refresh token")
 
 	if s.CurClientResponse.ClientID == "" {
-		color.Red.Println("==> Sorry, there is no Client ID")
-		panic(errNoClientID)
+		return fmt.Errorf("refresh token %w", errNoClientID)
 	}
 
-	if s.CurClientResponse.ClientSecret == "" {
-		color.Red.Println("==> Sorry, there is no Client Secret")
-		panic(errNoClientSecret)
+	if s.CurClientResponse.ClientSecret == "" && !s.PublicClient {
+		return fmt.Errorf("refresh token %w", errNoClientSecret)
 	}
 
 	if credsIAM.RefreshToken == "" {
-		color.Red.Println("==> Sorry, there is no Refresh Token")
-		panic(errNoRefreshToken)
+		return fmt.Errorf("refresh token %w", errNoRefreshToken)
 	}
 
-	v.Set("client_id", s.CurClientResponse.ClientID)
-	v.Set("client_secret", s.CurClientResponse.ClientSecret)
 	v.Set("grant_type", "refresh_token")
 	v.Set("refresh_token", credsIAM.RefreshToken)
+	s.OAuth.SetValues(v)
 
-	url, err := url.Parse(endpoint + "/token" + "?" + v.Encode())
-	if err != nil {
-		panic(err)
-	}
-
-	log.Debug().Str("url", url.String()).Msg("Refresh token")
+	tokenEndpoint := s.provider(endpoint).TokenEndpoint
 
-	req := http.Request{ // nolint:exhaustivestruct
-		Method: "POST",
-		URL:    url,
-	}
+	log.Debug().Str("url", tokenEndpoint).Msg("Refresh token")
 
-	// TODO: retrieve token with https POST with t.httpClient
-	r, err := s.Client.HTTPClient.Do(&req)
+	r, rbody, err := doWithRetry(&s.Client.HTTPClient, "refresh token", false, func(ctx context.Context) (*http.Request, error) {
+		return newTokenRequest(ctx, tokenEndpoint, v, s.CurClientResponse)
+	})
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("refresh token %w", err)
 	}
 
-	defer r.Body.Close()
 	log.Debug().Str("status", r.Status).Int("statusCode", r.StatusCode).Msg("Refresh token")
 
-	var (
-		bodyJSON RefreshTokenStruct
-		rbody    bytes.Buffer
-	)
+	var bodyJSON RefreshTokenStruct
 
-	_, err = rbody.ReadFrom(r.Body)
+	err = json.Unmarshal(rbody, &bodyJSON)
 	if err != nil {
-		panic(err)
-	}
-
-	log.Debug().Str("rbody", rbody.String()).Msg("Refresh token")
-
-	err = json.Unmarshal(rbody.Bytes(), &bodyJSON)
-	if err != nil {
-		panic(err)
+		return fmt.Errorf("refresh token response %s %w", r.Status, err)
 	}
 
 	// TODO
 	//encrToken := core.Encrypt([]byte(bodyJSON.AccessToken, passwd)
 
-	log.Debug().Str("newAccessToken", bodyJSON.AccessToken).Msg("Refresh token")
+	log.Debug().Bool("newAccessToken", bodyJSON.AccessToken != "").Bool("newRefreshToken",
+		bodyJSON.RefreshToken != "").Msg("Refresh token")
 
 	if bodyJSON.AccessToken == "" {
 		ref := reflect.TypeOf(&bodyJSON)
@@ -573,64 +809,87 @@ func (s *Server) RefreshToken(credsIAM IAMCreds, endpoint string) { //nolint:fun
 				bodyJSON.ErrorDescription).Msg("invalid access token")
 		}
 
-		panic("invalid access token")
+		return fmt.Errorf("refresh token response %s %w", r.Status, errInvalidAccessToken)
 	}
 
-	curFile, err := os.OpenFile(".token", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	storageToken, err := s.storageToken(bodyJSON.AccessToken)
 	if err != nil {
-		log.Err(err).Msg("server - token file")
+		return fmt.Errorf("refreshed access token %w", err)
 	}
 
-	_, err = curFile.Write([]byte(bodyJSON.AccessToken))
-	if err != nil {
-		log.Err(err).Msg("server - token file")
+	if err := writeTokenFile(storageToken); err != nil {
+		return fmt.Errorf("refresh token %w", err)
 	}
 
-	err = curFile.Close()
-	if err != nil {
-		log.Err(err).Msg("server - token file")
+	credsIAM.AccessToken = storageToken
+
+	// the previous refresh token is revoked when the server rotates it
+	if bodyJSON.RefreshToken != "" {
+		credsIAM.RefreshToken = bodyJSON.RefreshToken
 	}
 
-	if err != nil {
-		panic(err)
+	if s.STS.StaticCredentials() {
+		return s.renewCredentials(storageToken)
 	}
+
+	return nil
+}
+
+// healthCheckInterval returns the time between two checks of the mount point.
+func (s *Server) healthCheckInterval() time.Duration {
+	return durationOrDefault(s.HealthCheckInterval, checkRuntimeRcloneSleep)
 }
 
 func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:funlen,cyclop,lll,gocognit
 	loop := true
-	wg := sync.WaitGroup{}
+	// done stops the background checks at the exit of the loop
+	done := make(chan struct{})
 	signalChan := make(chan os.Signal, 1)
+	reloadSignalChan := make(chan os.Signal, 1)
+	s.reloadChan = make(chan chan reloadResponse)
+
+	// sleep waits the interval, it returns false at the exit of the loop
+	sleep := func(interval time.Duration) bool {
+		select {
+		case <-done:
+			return false
+		case <-time.After(interval):
+			return true
+		}
+	}
 
 	checkRuntimeRcloneErrors := func() {
-		time.Sleep(checkRuntimeRcloneSleep)
+		interval := s.readState().healthCheckInterval
 
-		for loop {
-			wg.Wait()
+		for sleep(interval) {
+			// the checks use a copy of the state, so a mount point that does
+			// not answer does not block the token loop
+			state := s.readState()
 
 			log.Debug().Msg("checkRuntimeRcloneErrors")
 
 			// -------------------------- LOG ROTATE ---------------------------
-			readFile, err := os.Open(s.rcloneLogPath)
+			readFile, err := os.Open(state.rcloneLogPath)
 			if err != nil {
-				log.Err(err).Str("logPath", s.rcloneLogPath).Msg("failed to open log file")
+				log.Err(err).Str("logPath", state.rcloneLogPath).Msg("failed to open log file")
 			}
 
 			fileInfo, err := readFile.Stat()
 			if err != nil {
-				log.Err(err).Str("logPath", s.rcloneLogPath).Msg("failed to get stats of the log file")
+				log.Err(err).Str("logPath", state.rcloneLogPath).Msg("failed to get stats of the log file")
 			}
 
 			readFile.Close()
 
 			if fileInfo.Size() >= oneMB*logMaxSizeMB {
-				go RcloneLogRotate(s.rcloneLogPath)
+				go RcloneLogRotate(state.rcloneLogPath)
 			}
 			// ------------------------ END LOG ROTATE -------------------------
 
 			foundErrors := false
 
 			// ------------------------ CHECK READ DIR -------------------------
-			localPathAbs, errLocalPath := filepath.Abs(s.LocalPath)
+			localPathAbs, errLocalPath := filepath.Abs(state.localPath)
 			if errLocalPath != nil {
 				log.Err(errLocalPath).Msg("server")
 			}
@@ -650,7 +909,7 @@ func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:
 			// ------------------------- END READ DIR --------------------------
 
 			// ----------------------- CHECK DUMMY FILE ------------------------
-			if !s.ReadOnly && !s.NoDummyFileCheck { // nolint:nestif
+			if !state.readOnly && !state.noDummyFileCheck { // nolint:nestif
 				dummyFile, err := os.CreateTemp(localPathAbs, ".dummy_*")
 
 				if err != nil {
@@ -683,59 +942,94 @@ func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:
 
 					foundErrors = true
 				}
+			}
+			// ------------------------ END DUMMY FILE -------------------------
 
-				// ------------------------ END DUMMY FILE -------------------------
-
-				// ---------------------- CHECK MOUNT POINT ------------------------
-				isMountPoint, err := checkMountpoint(localPathAbs)
-				if err != nil {
-					log.Debug().Err(err).Msg(
-						"checkRuntimeRcloneErrors - cannot check local mount point")
-
-					foundErrors = true
-				}
-
-				if !isMountPoint {
-					log.Debug().Err(err).Msg(
-						"checkRuntimeRcloneErrors - local mount point is not a mount point")
+			// ---------------------- CHECK MOUNT POINT ------------------------
+			mount, err := checkRcloneMount(localPathAbs, state.mountSource)
+			if err != nil {
+				log.Debug().Err(err).Msg(
+					"checkRuntimeRcloneErrors - local mount point is not the rclone mount")
 
-					foundErrors = true
-				}
-				// ----------------------- END MOUNT POINT -------------------------
+				foundErrors = true
+			} else {
+				log.Debug().Int("mountID", mount.ID).Interface("options", mount.Options).Msg(
+					"checkRuntimeRcloneErrors - local mount point")
+			}
+			// ----------------------- END MOUNT POINT -------------------------
 
-				log.Debug().Bool("errors", foundErrors).Msg("checkRuntimeRcloneErrors")
+			log.Debug().Bool("errors", foundErrors).Msg("checkRuntimeRcloneErrors")
 
-				if foundErrors {
-					log.Debug().Msg("checkRuntimeRcloneErrors - interrupt rclone process")
+			if foundErrors {
+				log.Debug().Msg("checkRuntimeRcloneErrors - interrupt rclone process")
 
-					errCmdInterrupt := s.rcloneCmd.Process.Signal(os.Interrupt)
-					if errCmdInterrupt != nil && !strings.Contains(errCmdInterrupt.Error(), "process already finished") {
-						panic(errCmdInterrupt)
-					}
+				errCmdInterrupt := state.rcloneProcess.Signal(os.Interrupt)
+				if errCmdInterrupt != nil && !strings.Contains(errCmdInterrupt.Error(), "process already finished") {
+					panic(errCmdInterrupt)
 				}
 			}
 
-			time.Sleep(checkRuntimeRcloneSleep)
+			interval = state.healthCheckInterval
 		}
 
 		log.Debug().Msg("checkRuntimeRcloneErrors - exit")
 	}
 	go checkRuntimeRcloneErrors()
 
+	periodicRefresh := func() {
+		lastRefresh := time.Now()
+
+		for sleep(refreshPollInterval) {
+			state := s.readState()
+
+			if state.refresh.Interval > 0 && time.Since(lastRefresh) >= state.refresh.Interval {
+				log.Debug().Msg("periodicRefresh")
+
+				state.refreshDirs()
+
+				lastRefresh = time.Now()
+			}
+		}
+
+		log.Debug().Msg("periodicRefresh - exit")
+	}
+	go periodicRefresh()
+
 	signal.Ignore(os.Interrupt)
 	signal.Notify(signalChan, os.Interrupt)
+	signal.Notify(reloadSignalChan, syscall.SIGHUP)
 
 	defer close(signalChan)
 
+	controlServer, errControl := s.startControlAPI()
+	if errControl != nil {
+		log.Warn().Err(errControl).Msg("UpdateTokenLoop - control API not available")
+	}
+
+	reload := func(trigger string) (ReloadResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		return s.reload(trigger)
+	}
+
 	startT := time.Now()
 
 	for loop {
 		if time.Since(startT)+deltaCheckTokenRefresh >= time.Duration(s.RefreshTokenRenew)*time.Minute { //nolint:nestif
 			startT = time.Now()
 
-			wg.Add(1)
-			s.RefreshToken(credsIAM, endpoint)
-			wg.Done()
+			s.mu.Lock()
+			errRefresh := s.RefreshToken(&credsIAM, endpoint)
+			s.mu.Unlock()
+
+			if errRefresh != nil {
+				log.Err(errRefresh).Msg("UpdateTokenLoop - refresh")
+				color.Red.Printf("==> Cannot renew the credentials: %s, retry in %s\n", errRefresh, credentialsRetryInterval)
+
+				// try again before the next refresh
+				startT = time.Now().Add(credentialsRetryInterval - time.Duration(s.RefreshTokenRenew)*time.Minute)
+			}
 		}
 
 		select {
@@ -745,12 +1039,21 @@ func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:
 
 			loop = false
 
-			log.Debug().Msg("Interrupt rclone process")
+			// the state is only read from now on
+			s.flushCache(signalChan)
+
+			log.Debug().Msg("Stop rclone process")
 
-			errCmdInterrupt := s.rcloneCmd.Process.Signal(os.Interrupt)
-			if errCmdInterrupt != nil && !strings.Contains(errCmdInterrupt.Error(), "process already finished") {
-				panic(errCmdInterrupt)
+			if errStop := s.stopRclone(); errStop != nil {
+				panic(errStop)
 			}
+		case <-reloadSignalChan:
+			if _, err := reload("SIGHUP"); err != nil {
+				log.Err(err).Msg("UpdateTokenLoop - reload")
+			}
+		case request := <-s.reloadChan:
+			result, err := reload("control API")
+			request <- reloadResponse{result: result, err: err}
 		case <-s.rcloneErrChan:
 			log.Debug().Msg("Unexpected rclone process exit")
 
@@ -780,6 +1083,7 @@ func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:
 				if err != nil {
 					color.Red.Println("==> Error, cannot unmount local folder...")
 				} else {
+					s.mu.Lock()
 					rcloneCmd, errChan, logPath, errMount := MountVolume(s)
 
 					if errMount != nil {
@@ -789,6 +1093,7 @@ func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:
 					s.rcloneCmd = rcloneCmd
 					s.rcloneErrChan = errChan
 					s.rcloneLogPath = logPath
+					s.mu.Unlock()
 				}
 			} else {
 				color.Yellow.Println("==> Check the logs for more details...")
@@ -803,7 +1108,14 @@ func (s *Server) UpdateTokenLoop(credsIAM IAMCreds, endpoint string) { //nolint:
 		time.Sleep(750 * time.Millisecond)
 	}
 
+	close(done)
 	signal.Stop(signalChan)
+	signal.Stop(reloadSignalChan)
+
+	if controlServer != nil {
+		controlServer.Close()
+		os.Remove(controlSocket(s.Client.ConfDir))
+	}
 
 	log.Debug().Msg("UpdateTokenLoop exit")
 	time.Sleep(1 * time.Second)
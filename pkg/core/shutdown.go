@@ -0,0 +1,82 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultShutdownTimeout = 5 * time.Minute
+	uploadsPollInterval    = 1 * time.Second
+)
+
+// waitUploads waits, up to the shutdown timeout, the uploads of the files in
+// the local cache, showing the progress, or until abort. It returns the files
+// still to upload, or -1 if rclone cannot be asked.
+func (s *Server) waitUploads(abort <-chan os.Signal) int {
+	if strings.EqualFold(s.LocalCache, "off") {
+		return 0
+	}
+
+	deadline := time.Now().Add(s.ShutdownTimeout)
+	showProgress := false
+
+	defer func() {
+		if showProgress {
+			fmt.Println()
+		}
+	}()
+
+	for {
+		stats, err := s.rc.VFSStats(context.Background())
+		if err != nil {
+			log.Warn().Err(err).Msg("shutdown - cannot check the uploads of the cache")
+
+			return -1
+		}
+
+		pending := stats.PendingUploads()
+		if pending == 0 || !time.Now().Before(deadline) {
+			return pending
+		}
+
+		log.Debug().Int("inProgress", stats.DiskCache.UploadsInProgress).Int("queued",
+			stats.DiskCache.UploadsQueued).Msg("shutdown - wait uploads")
+
+		showProgress = true
+
+		fmt.Printf("\r==> Uploading the local cache: %d in progress, %d queued (%s left, Ctrl+C to stop)  ",
+			stats.DiskCache.UploadsInProgress, stats.DiskCache.UploadsQueued,
+			time.Until(deadline).Round(time.Second))
+
+		select {
+		case <-abort:
+			log.Debug().Msg("shutdown - wait uploads aborted")
+
+			abort = nil
+			deadline = time.Now()
+		case <-time.After(uploadsPollInterval):
+		}
+	}
+}
+
+// flushCache waits the uploads of the local cache before stopping rclone and
+// reports the files left in the cache.
+func (s *Server) flushCache(abort <-chan os.Signal) {
+	pending := s.waitUploads(abort)
+
+	switch {
+	case pending > 0:
+		log.Warn().Int("files", pending).Str("cacheDir", s.LocalCacheDir).Msg("shutdown - files not uploaded")
+		color.Red.Printf("==> %d files are not uploaded yet: they are kept in %s and uploaded at the next mount\n",
+			pending, s.LocalCacheDir)
+	case pending < 0:
+		color.Yellow.Printf("==> Cannot check the uploads, the files not uploaded are kept in %s\n", s.LocalCacheDir)
+	}
+}
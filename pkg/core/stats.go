@@ -0,0 +1,95 @@
+package core
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/DODAS-TS/sts-wire/pkg/rclone"
+	"github.com/gookit/color"
+)
+
+const bytesUnit = 1024
+
+// instanceStats are the statistics of a running instance.
+type instanceStats struct {
+	Transfers rclone.CoreStats `json:"transfers"`
+	VFS       rclone.VFSStats  `json:"vfs"`
+	BwLimit   rclone.BwLimit   `json:"bwLimit"`
+}
+
+// stats asks the statistics to rclone.
+func (l liveState) stats() (instanceStats, error) {
+	var (
+		stats instanceStats
+		err   error
+	)
+
+	ctx := context.Background()
+
+	if stats.Transfers, err = l.rc.Stats(ctx); err != nil {
+		return stats, fmt.Errorf("stats %w", err)
+	}
+
+	if stats.VFS, err = l.rc.VFSStats(ctx); err != nil {
+		return stats, fmt.Errorf("stats %w", err)
+	}
+
+	if stats.BwLimit, err = l.rc.BwLimit(ctx, ""); err != nil {
+		return stats, fmt.Errorf("stats %w", err)
+	}
+
+	return stats, nil
+}
+
+// formatBytes returns the size with the binary unit, e.g. 1.5 MiB.
+func formatBytes(size int64) string {
+	if size < bytesUnit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	div, exp := int64(bytesUnit), 0
+	for n := size / bytesUnit; n >= bytesUnit; n /= bytesUnit {
+		div *= bytesUnit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// printStats prints the statistics of an instance.
+func printStats(instance string, stats instanceStats) {
+	transfers := stats.Transfers
+
+	color.Green.Printf("==> Stats of %s (%s)\n", instance, stats.VFS.Fs)
+	fmt.Printf("    transfers:   %d/%d done, %s/%s at %s/s\n", transfers.Transfers, transfers.TotalTransfers,
+		formatBytes(transfers.Bytes), formatBytes(transfers.TotalBytes), formatBytes(int64(transfers.Speed)))
+
+	for _, transfer := range transfers.Transferring {
+		fmt.Printf("      %-40s %3d%% of %s at %s/s\n", transfer.Name, transfer.Percentage,
+			formatBytes(transfer.Size), formatBytes(int64(transfer.Speed)))
+	}
+
+	fmt.Printf("    checks:      %d, deletes: %d, renames: %d\n", transfers.Checks, transfers.Deletes, transfers.Renames)
+	fmt.Printf("    open files:  %d\n", stats.VFS.InUse)
+	fmt.Printf("    bandwidth:   %s\n", stats.BwLimit.Rate)
+
+	if cache := stats.VFS.DiskCache; cache != nil {
+		fmt.Printf("    cache:       %d files, %s in %s\n", cache.Files, formatBytes(cache.BytesUsed), cache.Path)
+		fmt.Printf("    uploads:     %d in progress, %d queued, %d errored\n",
+			cache.UploadsInProgress, cache.UploadsQueued, cache.ErroredFiles)
+
+		if cache.OutOfSpace {
+			color.Red.Println("    the cache is out of space")
+		}
+	} else {
+		fmt.Println("    cache:       off")
+	}
+
+	if transfers.Errors == 0 {
+		fmt.Println("    errors:      0")
+
+		return
+	}
+
+	color.Red.Printf("    errors:      %d, last error: %s\n", transfers.Errors, transfers.LastError)
+}
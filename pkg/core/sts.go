@@ -0,0 +1,236 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/minio/minio-go/v6/pkg/credentials"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	clientCredentialsTimeout = 30 * time.Second
+	// packedPolicySizeWarning is the percentage of the maximum policy size
+	// allowed by the STS over which the user is warned.
+	packedPolicySizeWarning = 90
+	// credentialsRenewMargin is the time before the expiration of the STS
+	// credentials, after the next refresh, within which they are renewed.
+	credentialsRenewMargin = 5 * time.Minute
+)
+
+var (
+	errClientGrantsNoClient = errors.New("the AssumeRoleWithClientGrants action needs a static client with a secret")
+	errMissingSTSParam      = errors.New("missing parameter of the STS action")
+)
+
+// stsCredentials asks the STS the temporary credentials for the configured action.
+func (s *Server) stsCredentials(token string) (credentials.Value, error) {
+	provider := &IAMProvider{ // nolint:exhaustivestruct
+		StsEndpoint:       s.S3Endpoint,
+		Token:             token,
+		HTTPClient:        &s.Client.HTTPClient,
+		RefreshTokenRenew: s.RefreshTokenRenew,
+		STS:               s.STS,
+	}
+
+	creds, err := credentials.New(provider).Get()
+	if err != nil {
+		printSTSError(err)
+
+		return creds, fmt.Errorf("STS credentials %w", err)
+	}
+
+	s.stsCreds = creds
+	s.stsExpiration = provider.Creds.STSCredentials().Expiration
+	s.packedPolicySize = provider.Creds.STSPackedPolicySize()
+
+	log.Info().Str("action", s.STS.Action).Str("roleArn", s.STS.RoleArn).Int("packedPolicySize",
+		s.packedPolicySize).Time("expiration", s.stsExpiration).Msg("server - STS credentials")
+
+	if s.STS.Policy != "" {
+		color.Green.Printf("==> Session policy applied, packed policy size: %d%%\n", s.packedPolicySize)
+
+		if s.packedPolicySize >= packedPolicySizeWarning {
+			color.Yellow.Println("==> The session policy is close to the maximum size allowed by the STS")
+		}
+	}
+
+	return creds, nil
+}
+
+// clientCredentialsToken gets a token with the client credentials grant of the
+// static client. It is used by the robot accounts with the
+// AssumeRoleWithClientGrants action, without any user interaction.
+func (s *Server) clientCredentialsToken() (string, error) {
+	if s.CurClientResponse.ClientID == "" || s.CurClientResponse.ClientSecret == "" {
+		return "", errClientGrantsNoClient
+	}
+
+	endpointParams := url.Values{}
+	s.OAuth.setParams(endpointParams)
+
+	config := clientcredentials.Config{ // nolint:exhaustivestruct
+		ClientID:       s.CurClientResponse.ClientID,
+		ClientSecret:   s.CurClientResponse.ClientSecret,
+		TokenURL:       s.provider(s.Endpoint).TokenEndpoint,
+		Scopes:         s.OAuth.Scopes,
+		EndpointParams: endpointParams,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), clientCredentialsTimeout)
+	defer cancel()
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &s.Client.HTTPClient)
+
+	oauth2Token, err := config.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("client credentials %w", err)
+	}
+
+	return s.storageToken(oauth2Token.AccessToken)
+}
+
+// clientGrantsLogin gets the credentials of a robot account.
+func (s *Server) clientGrantsLogin() IAMCreds {
+	token, err := s.clientCredentialsToken()
+	if err != nil {
+		color.Red.Printf("==> Cannot get a token with the client credentials: %s\n", err)
+		panic(err)
+	}
+
+	if err := writeTokenFile(token); err != nil {
+		panic(err)
+	}
+
+	if _, err := s.stsCredentials(token); err != nil {
+		log.Err(err).Msg("server - client grants")
+		panic(err)
+	}
+
+	return IAMCreds{AccessToken: token} // nolint:exhaustivestruct
+}
+
+// noTokenLogin gets the credentials for the STS actions that do not need a
+// token of the IAM server.
+func (s *Server) noTokenLogin() IAMCreds {
+	if _, err := s.stsCredentials(""); err != nil {
+		log.Err(err).Str("action", s.STS.Action).Msg("server - STS")
+		panic(err)
+	}
+
+	return IAMCreds{} // nolint:exhaustivestruct
+}
+
+// credentialsExpiring reports if the STS credentials expire before the next
+// refresh, with a margin. Without an expiration they are always renewed.
+func (s *Server) credentialsExpiring() bool {
+	if s.stsExpiration.IsZero() {
+		return true
+	}
+
+	nextRefresh := time.Duration(s.RefreshTokenRenew) * time.Minute
+
+	return time.Until(s.stsExpiration) < nextRefresh+credentialsRenewMargin
+}
+
+// renewCredentials asks new STS credentials and restarts rclone with them,
+// only when the current ones are about to expire.
+func (s *Server) renewCredentials(token string) error {
+	if !s.credentialsExpiring() {
+		log.Debug().Time("expiration", s.stsExpiration).Msg("server - credentials still valid")
+
+		return nil
+	}
+
+	log.Debug().Str("action", s.STS.Action).Time("expiration", s.stsExpiration).Msg("server - renew credentials")
+
+	if _, err := s.stsCredentials(token); err != nil {
+		return fmt.Errorf("renew credentials %w", err)
+	}
+
+	if err := s.writeRcloneConfig(); err != nil {
+		return fmt.Errorf("renew credentials %w", err)
+	}
+
+	if s.rcloneCmd == nil {
+		return nil
+	}
+
+	if err := s.remount(); err != nil {
+		return fmt.Errorf("renew credentials %w", err)
+	}
+
+	return nil
+}
+
+// writeTokenFile saves the token used by rclone.
+func writeTokenFile(token string) error {
+	curFile, err := os.OpenFile(".token", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("token file %w", err)
+	}
+
+	defer curFile.Close()
+
+	if _, err := curFile.Write([]byte(token)); err != nil {
+		return fmt.Errorf("token file %w", err)
+	}
+
+	return nil
+}
+
+// printSTSError shows the error of the STS with a hint for the user.
+func printSTSError(err error) {
+	var errSTS *STSError
+	if !errors.As(err, &errSTS) {
+		color.Red.Printf("==> Could not get STS credentials: %s\n", err)
+
+		return
+	}
+
+	color.Red.Printf("==> The STS refused the credentials: %s\n", errSTS)
+
+	if hint := errSTS.Hint(); hint != "" {
+		color.Yellow.Printf("==> Hint: %s\n", hint)
+	}
+}
+
+// stsErrorPage returns the error page with the message of the STS.
+func stsErrorPage(err error) []byte {
+	var (
+		errSTS *STSError
+		page   bytes.Buffer
+		data   struct{ Message, Hint string }
+	)
+
+	if errors.As(err, &errSTS) {
+		data.Message = errSTS.Code + ": " + errSTS.Message
+		data.Hint = errSTS.Hint()
+	} else {
+		data.Message = err.Error()
+	}
+
+	tmpl, errParse := htmltemplate.New("errorNoStsCred").Parse(string(htmlErrorNoStsCred))
+	if errParse != nil {
+		log.Err(errParse).Msg("server - STS error page")
+
+		return htmlErrorNoStsCred
+	}
+
+	if errExec := tmpl.Execute(&page, data); errExec != nil {
+		log.Err(errExec).Msg("server - STS error page")
+
+		return htmlErrorNoStsCred
+	}
+
+	return page.Bytes()
+}
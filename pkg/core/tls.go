@@ -0,0 +1,207 @@
+package core
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+const caBundleFilename = "ca-bundle.pem"
+
+var (
+	errNoCertificates   = errors.New("no PEM certificates found")
+	errClientCertNoKey  = errors.New("the client certificate needs the client key and vice versa")
+	caDirFileExtensions = []string{".pem", ".crt", ".cer"} // nolint:gochecknoglobals
+	// systemCAFiles are the usual locations of the system CA bundle.
+	systemCAFiles = []string{ // nolint:gochecknoglobals
+		"/etc/ssl/certs/ca-certificates.crt",
+		"/etc/pki/tls/certs/ca-bundle.crt",
+		"/etc/ssl/ca-bundle.pem",
+		"/etc/pki/tls/cacert.pem",
+		"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+		"/etc/ssl/cert.pem",
+	}
+)
+
+// TLSConfig of the connections to the IAM server, the STS and the S3 endpoint.
+type TLSConfig struct {
+	Insecure   bool
+	CAFile     string
+	CADir      string
+	ClientCert string
+	ClientKey  string
+}
+
+// customCAs returns the PEM certificates of the CA file and of the CA directory.
+func (c TLSConfig) customCAs() ([]byte, error) {
+	var certs bytes.Buffer
+
+	files := []string{}
+
+	if c.CAFile != "" {
+		files = append(files, c.CAFile)
+	}
+
+	if c.CADir != "" {
+		entries, err := os.ReadDir(c.CADir)
+		if err != nil {
+			return nil, fmt.Errorf("CA dir %w", err)
+		}
+
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if entry.IsDir() || !contains(caDirFileExtensions, ext) {
+				continue
+			}
+
+			files = append(files, filepath.Join(c.CADir, entry.Name()))
+		}
+	}
+
+	for _, file := range files {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("CA file %w", err)
+		}
+
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w in %s", errNoCertificates, file)
+		}
+
+		certs.Write(bytes.TrimSpace(pem))
+		certs.WriteRune('\n')
+
+		log.Debug().Str("file", file).Msg("tls - CA added")
+	}
+
+	return certs.Bytes(), nil
+}
+
+// Build returns the TLS configuration with the custom CAs added to the system
+// pool and the client certificate.
+func (c TLSConfig) Build() (*tls.Config, error) {
+	cfg := &tls.Config{ // nolint:exhaustivestruct
+		InsecureSkipVerify: c.Insecure, // nolint:gosec
+	}
+
+	customCAs, err := c.customCAs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(customCAs) != 0 {
+		pool, errPool := x509.SystemCertPool()
+		if errPool != nil || pool == nil {
+			log.Warn().Err(errPool).Msg("tls - system CA pool not available, only the custom CAs are trusted")
+
+			pool = x509.NewCertPool()
+		}
+
+		pool.AppendCertsFromPEM(customCAs)
+		cfg.RootCAs = pool
+	}
+
+	if c.ClientCert != "" || c.ClientKey != "" {
+		if c.ClientCert == "" || c.ClientKey == "" {
+			return nil, errClientCertNoKey
+		}
+
+		cert, errCert := tls.LoadX509KeyPair(c.ClientCert, c.ClientKey)
+		if errCert != nil {
+			return nil, fmt.Errorf("client certificate %w", errCert)
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// RcloneArgs returns the rclone flags with the same trust settings. rclone
+// accepts a single CA file that replaces the system pool, so a bundle with the
+// system CAs and the custom ones is written in the configuration directory.
+func (c TLSConfig) RcloneArgs(confDir string) ([]string, error) {
+	if c.Insecure {
+		return []string{"--no-check-certificate"}, nil
+	}
+
+	args := []string{}
+
+	customCAs, err := c.customCAs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(customCAs) != 0 {
+		var bundle bytes.Buffer
+
+		if systemCAs := systemCABundle(); len(systemCAs) != 0 {
+			bundle.Write(systemCAs)
+			bundle.WriteRune('\n')
+		} else {
+			log.Warn().Msg("tls - system CA bundle not found, rclone trusts only the custom CAs")
+		}
+
+		bundle.Write(customCAs)
+
+		bundlePath, errAbs := filepath.Abs(filepath.Join(confDir, caBundleFilename))
+		if errAbs != nil {
+			return nil, fmt.Errorf("CA bundle %w", errAbs)
+		}
+
+		if errWrite := os.WriteFile(bundlePath, bundle.Bytes(), fileMode); errWrite != nil {
+			return nil, fmt.Errorf("CA bundle %w", errWrite)
+		}
+
+		args = append(args, "--ca-cert", bundlePath)
+	}
+
+	if c.ClientCert != "" && c.ClientKey != "" {
+		clientCert, errCert := filepath.Abs(c.ClientCert)
+		if errCert != nil {
+			return nil, fmt.Errorf("client certificate %w", errCert)
+		}
+
+		clientKey, errKey := filepath.Abs(c.ClientKey)
+		if errKey != nil {
+			return nil, fmt.Errorf("client key %w", errKey)
+		}
+
+		args = append(args, "--client-cert", clientCert, "--client-key", clientKey)
+	}
+
+	return args, nil
+}
+
+// systemCABundle returns the content of the system CA bundle, if any.
+func systemCABundle() []byte {
+	files := systemCAFiles
+	if envFile := os.Getenv("SSL_CERT_FILE"); envFile != "" {
+		files = append([]string{envFile}, files...)
+	}
+
+	for _, file := range files {
+		if pem, err := os.ReadFile(file); err == nil {
+			return bytes.TrimSpace(pem)
+		}
+	}
+
+	return nil
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
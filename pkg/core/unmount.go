@@ -0,0 +1,122 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gookit/color"
+	"github.com/rs/zerolog/log"
+)
+
+// instanceStopPollInterval is the time between two checks of the exit of an
+// instance asked to stop.
+const instanceStopPollInterval = time.Second
+
+var errNoUnmount = errors.New("not available on Windows, the volume is unmounted at the exit of rclone")
+
+// stopRclone asks rclone to unmount the volume and to exit with the remote
+// control API, with an interrupt if it does not answer.
+func (s *Server) stopRclone() error {
+	errQuit := s.rc.Quit(context.Background(), 0)
+	if errQuit == nil {
+		log.Debug().Msg("stopRclone - quit")
+
+		return nil
+	}
+
+	log.Debug().Err(errQuit).Msg("stopRclone - quit failed, interrupt rclone process")
+
+	errInterrupt := s.rcloneCmd.Process.Signal(os.Interrupt)
+	if errInterrupt != nil && !strings.Contains(errInterrupt.Error(), "process already finished") {
+		return fmt.Errorf("stop rclone %w", errInterrupt)
+	}
+
+	return nil
+}
+
+// umountInstance stops a running instance, like Ctrl+C, or unmounts the
+// mount points left by the instance, e.g. after a crash.
+func umountInstance(instance string) error {
+	var status controlStatus
+
+	if err := controlRequest("."+instance, http.MethodGet, "/status", nil, &status); err == nil {
+		return stopInstance(instance, status)
+	}
+
+	mountPoints, err := instanceMountPoints(instance)
+	if err != nil {
+		return err
+	}
+
+	if len(mountPoints) == 0 {
+		color.Green.Printf("==> %s is not running and has no mount points\n", instance)
+
+		return nil
+	}
+
+	for _, mountPoint := range mountPoints {
+		if err := umountPath(mountPoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopInstance interrupts a running instance, that waits for the uploads of
+// the local cache and unmounts the volume, and waits for its exit.
+func stopInstance(instance string, status controlStatus) error {
+	process, err := os.FindProcess(status.PID)
+	if err == nil {
+		err = process.Signal(os.Interrupt)
+	}
+
+	if err != nil {
+		return fmt.Errorf("stop %s (pid %d) %w", instance, status.PID, err)
+	}
+
+	color.Yellow.Printf("==> Stopping %s (pid %d), it waits for the uploads of the local cache...\n",
+		instance, status.PID)
+
+	for controlRequest("."+instance, http.MethodGet, "/status", nil, &status) == nil {
+		time.Sleep(instanceStopPollInterval)
+	}
+
+	// The mount point is left if rclone did not exit cleanly
+	if runtime.GOOS != "windows" {
+		if mounted, errCheck := checkMountpoint(status.MountPoint); errCheck != nil || mounted {
+			return umountPath(status.MountPoint)
+		}
+	}
+
+	color.Green.Printf("==> %s stopped, %s unmounted\n", instance, status.MountPoint)
+
+	return nil
+}
+
+// umountPath unmounts a mount point.
+func umountPath(path string) error {
+	if runtime.GOOS == "windows" {
+		return errNoUnmount
+	}
+
+	if mounted, err := checkMountpoint(path); err == nil && !mounted {
+		color.Green.Printf("==> %s is not a mount point\n", path)
+
+		return nil
+	}
+
+	if err := unmount(path); err != nil {
+		return err
+	}
+
+	color.Green.Printf("==> %s unmounted\n", path)
+
+	return nil
+}
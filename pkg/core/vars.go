@@ -40,4 +40,6 @@ var (
 	htmlMountingPage []byte
 	//go:embed "data/html/errorNoStsCred.html"
 	htmlErrorNoStsCred []byte
+	//go:embed "data/html/errorInvalidToken.html"
+	htmlErrorInvalidToken []byte
 )
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DODAS-TS/sts-wire/pkg/oidc"
+)
+
+// buildWhoami describes the identity of the access token and checks it
+// against the keys of its issuer.
+func buildWhoami(httpClient *http.Client, rawToken string, audience []string) string {
+	token, err := oidc.ParseUnverified(rawToken)
+	if err != nil {
+		panic(err)
+	}
+
+	verification := "verified"
+
+	provider, errDiscover := oidc.Discover(httpClient, token.Claims.Issuer)
+	if errDiscover != nil {
+		verification = fmt.Sprintf("NOT verified (%s)", errDiscover)
+	} else {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenVerifyTimeout)
+		defer cancel()
+
+		verifier := oidc.NewVerifier(provider, oidc.NewKeySet(httpClient, provider.JwksURI), audience)
+		if _, errVerify := verifier.Verify(ctx, rawToken); errVerify != nil {
+			verification = fmt.Sprintf("NOT verified (%s)", errVerify)
+		}
+	}
+
+	claims := token.Claims
+
+	expiry := "never"
+	if !claims.Expiry().IsZero() {
+		remaining := time.Until(claims.Expiry()).Round(time.Second)
+		if remaining > 0 {
+			expiry = fmt.Sprintf("%s (in %s)", claims.Expiry().Format(time.RFC3339), remaining)
+		} else {
+			expiry = fmt.Sprintf("%s (expired %s ago)", claims.Expiry().Format(time.RFC3339), -remaining)
+		}
+	}
+
+	whoamiString := strings.Builder{}
+	whoamiString.WriteString(divider)
+	whoamiString.WriteRune('\n')
+	whoamiString.WriteString(fmt.Sprintf(" Subject:\t\t%s\n", claims.Subject))
+
+	if claims.PreferredUsername != "" {
+		whoamiString.WriteString(fmt.Sprintf(" Username:\t\t%s\n", claims.PreferredUsername))
+	}
+
+	whoamiString.WriteString(fmt.Sprintf(" Issuer:\t\t%s\n", claims.Issuer))
+	whoamiString.WriteString(fmt.Sprintf(" Audience:\t\t%s\n", strings.Join(claims.Audience, ", ")))
+	whoamiString.WriteString(fmt.Sprintf(" Client ID:\t\t%s\n", claims.ClientID))
+	whoamiString.WriteString(fmt.Sprintf(" Groups:\t\t%s\n", strings.Join(claims.AllGroups(), ", ")))
+	whoamiString.WriteString(fmt.Sprintf(" Scopes:\t\t%s\n", strings.Join(claims.Scopes(), ", ")))
+	whoamiString.WriteString(fmt.Sprintf(" Expiry:\t\t%s\n", expiry))
+	whoamiString.WriteString(fmt.Sprintf(" Signature:\t\t%s\n", verification))
+	whoamiString.WriteString(divider)
+
+	return whoamiString.String()
+}
@@ -0,0 +1,307 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/DODAS-TS/sts-wire/pkg/oidc"
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+	"github.com/c-bata/go-prompt"
+	"github.com/gookit/color"
+	"github.com/mitchellh/go-homedir"
+)
+
+const (
+	defaultIAMServer = "https://iam-demo.cloud.cnaf.infn.it"
+	probeTimeout     = 10 * time.Second
+	configDirName    = ".sts-wire"
+	configDirMode    = 0700
+	wizardHeader     = "# sts-wire configuration written by 'sts-wire init'.\n" +
+		"# Each key can be overridden by a flag or by the STS_WIRE_<KEY> environment variable.\n\n"
+)
+
+var (
+	errNoAnswer      = errors.New("empty answer")
+	errNoValidChoice = errors.New("not a valid choice")
+	errNoValidAnswer = errors.New("answer yes or no")
+	errWizardAborted = errors.New("init aborted, the config file was not written")
+	localCacheModes  = []string{"off", "minimal", "writes", "full"} // nolint:gochecknoglobals
+)
+
+// Wizard asks the values of a new configuration to the user.
+type Wizard struct {
+	Scanner    GetInputWrapper
+	HTTPClient *http.Client
+}
+
+// ask repeats the question until the answer passes the check. An empty answer
+// selects the default value, if any.
+func (w *Wizard) ask(question string, def string, check func(string) (bool, error)) string {
+	for {
+		answer, err := w.Scanner.GetInputString(question, def)
+		if err != nil {
+			panic(err)
+		}
+
+		answer = strings.TrimSpace(answer)
+
+		if answer == "" {
+			color.Red.Printf("==> %s\n", errNoAnswer)
+
+			continue
+		}
+
+		if check != nil {
+			if valid, errCheck := check(answer); !valid || errCheck != nil {
+				color.Red.Printf("==> %s\n", errCheck)
+
+				continue
+			}
+		}
+
+		return answer
+	}
+}
+
+// optional asks a value that can be left empty.
+func (w *Wizard) optional(question string, check func(string) (bool, error)) string {
+	for {
+		answer, err := w.Scanner.GetInputString(question+" (press enter to skip)", "")
+		if err != nil {
+			panic(err)
+		}
+
+		answer = strings.TrimSpace(answer)
+
+		if answer == "" || check == nil {
+			return answer
+		}
+
+		if valid, errCheck := check(answer); !valid || errCheck != nil {
+			color.Red.Printf("==> %s\n", errCheck)
+
+			continue
+		}
+
+		return answer
+	}
+}
+
+// confirm asks a yes or no question.
+func (w *Wizard) confirm(question string, def bool) bool {
+	defAnswer := "no"
+	if def {
+		defAnswer = "yes"
+	}
+
+	answer := w.ask(question+" [yes/no]", defAnswer, func(answer string) (bool, error) {
+		switch strings.ToLower(answer) {
+		case "y", "yes", "n", "no":
+			return true, nil
+		default:
+			return false, errNoValidAnswer
+		}
+	})
+
+	return strings.HasPrefix(strings.ToLower(answer), "y")
+}
+
+// choose asks to select one of the choices, with completion when the input
+// is a terminal.
+func (w *Wizard) choose(question string, choices []string, descriptions []string, def string) string {
+	if !isTerminal(os.Stdin) {
+		return w.ask(fmt.Sprintf("%s [%s]", question, strings.Join(choices, ",")), def,
+			func(answer string) (bool, error) {
+				if !contains(choices, answer) {
+					return false, fmt.Errorf("%w: %s", errNoValidChoice, answer)
+				}
+
+				return true, nil
+			})
+	}
+
+	suggestions := make([]prompt.Suggest, 0, len(choices))
+	for idx, choice := range choices {
+		suggestions = append(suggestions, prompt.Suggest{Text: choice, Description: descriptions[idx]})
+	}
+
+	completer := func(d prompt.Document) []prompt.Suggest {
+		return prompt.FilterHasPrefix(suggestions, d.GetWordBeforeCursor(), true)
+	}
+
+	for {
+		fmt.Printf("%s %s (press enter for default [%s]):\n", color.Yellow.Sprint("|=>"), question, def)
+
+		answer := strings.TrimSpace(prompt.Input("> ", completer))
+		if answer == "" {
+			return def
+		}
+
+		if contains(choices, answer) {
+			return answer
+		}
+
+		color.Red.Printf("==> %s: %s\n", errNoValidChoice, answer)
+	}
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+
+	// The null device is a character device too
+	devNull, err := os.Stat(os.DevNull)
+
+	return err != nil || !os.SameFile(info, devNull)
+}
+
+// keepAnyway asks if a value that failed a check has to be kept.
+func (w *Wizard) keepAnyway(what string, err error) bool {
+	color.Yellow.Printf("==> Cannot check the %s: %s\n", what, err)
+
+	return w.confirm("Keep it anyway?", false)
+}
+
+// probeS3 checks that the S3 endpoint answers to an HTTP request. Any HTTP
+// response is fine, e.g. an anonymous request is usually forbidden.
+func probeS3(httpClient *http.Client, endpoint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("S3 endpoint %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("S3 endpoint %w", err)
+	}
+
+	resp.Body.Close()
+
+	return nil
+}
+
+// Run asks all the values and returns the new configuration.
+func (w *Wizard) Run() Config { // nolint:funlen
+	cfg := DefaultConfig()
+
+	color.Green.Println("==> IAM server")
+
+	for {
+		cfg.IAMServer = w.ask("Insert the IAM server URL", defaultIAMServer, validator.WebURL)
+
+		if !w.confirm("Check the IAM server discovery document?", true) {
+			break
+		}
+
+		provider, err := oidc.Discover(w.HTTPClient, cfg.IAMServer)
+		if err == nil {
+			color.Green.Printf("==> IAM server found, issuer: %s\n", provider.Issuer)
+
+			if provider.RegistrationEndpoint == "" {
+				color.Yellow.Println("==> The IAM server does not support the dynamic client registration, " +
+					"set iamClientID and iamClientSecret in the config file")
+			}
+
+			break
+		}
+
+		if w.keepAnyway("IAM server", err) {
+			break
+		}
+	}
+
+	cfg.InstanceName = w.ask("Insert the instance name", "", validator.InstanceName)
+
+	color.Green.Println("==> Storage")
+
+	for {
+		cfg.S3Endpoint = w.ask("Insert the S3 endpoint URL", "", validator.S3Endpoint)
+
+		err := probeS3(w.HTTPClient, cfg.S3Endpoint)
+		if err == nil {
+			color.Green.Println("==> S3 endpoint reachable")
+
+			break
+		}
+
+		if w.keepAnyway("S3 endpoint", err) {
+			break
+		}
+	}
+
+	cfg.RcloneRemotePath = w.ask("Insert the bucket path to mount, e.g. /bucket/folder", "", validator.RemotePath)
+
+	home, err := homedir.Dir()
+	if err != nil {
+		panic(err)
+	}
+
+	cfg.LocalMountPoint = w.ask("Insert the local mount point", filepath.Join(home, cfg.InstanceName),
+		validator.LocalPath)
+
+	cfg.LocalCache = w.choose("Select the local cache mode", localCacheModes, []string{
+		"no cache, files are read and written directly",
+		"cache only the files opened for read and write",
+		"cache the files opened for write",
+		"cache all the files",
+	}, cfg.LocalCache)
+
+	if cfg.LocalCache != "off" {
+		cfg.LocalCacheDir = w.ask("Insert the local cache folder", cfg.LocalCacheDir, validator.LocalPath)
+	}
+
+	color.Green.Println("==> Security")
+
+	cfg.ReadOnly = w.confirm("Mount the bucket read-only?", false)
+	cfg.NoPassword = !w.confirm("Encrypt the IAM client credentials with a password?", true)
+	cfg.CAFile = w.optional("Insert a PEM file with extra CA certificates", func(file string) (bool, error) {
+		_, errCA := TLSConfig{CAFile: file}.customCAs() // nolint:exhaustivestruct
+
+		return errCA == nil, errCA
+	})
+
+	if cfg.CAFile == "" {
+		cfg.InsecureConn = w.confirm("Skip the verification of the server certificates (not safe)?", false)
+	}
+
+	return cfg
+}
+
+// defaultWizardFile returns the config file searched in the user folder.
+func defaultWizardFile() string {
+	home, err := homedir.Dir()
+	if err != nil {
+		panic(err)
+	}
+
+	return filepath.Join(home, configDirName, defaultConfigFile)
+}
+
+// writeWizardConfig writes the configuration with the description of each key.
+func writeWizardConfig(cfg Config, configFilename string) error {
+	document, err := cfg.YAML(true, false)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(configFilename), configDirMode); err != nil {
+		return fmt.Errorf("config folder %w", err)
+	}
+
+	if err := os.WriteFile(configFilename, append([]byte(wizardHeader), document...), configFileMode); err != nil {
+		return fmt.Errorf("config file %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+// Package mountinfo reads the mount points of the process from the Linux
+// /proc/self/mountinfo file.
+package mountinfo
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Path is the mountinfo file of the current process.
+const Path = "/proc/self/mountinfo"
+
+const (
+	minFields      = 10
+	octalEscapeLen = 4
+)
+
+var (
+	ErrNoValidLine     = errors.New("no valid mountinfo line")
+	ErrNotMounted      = errors.New("not a mount point")
+	ErrOtherFilesystem = errors.New("another filesystem is mounted")
+)
+
+// Mount is a line of mountinfo, see proc(5):
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+type Mount struct {
+	ID       int
+	ParentID int
+	// Device is major:minor of the filesystem
+	Device     string
+	Root       string
+	MountPoint string
+	// Options of the mount point, e.g. rw, nosuid
+	Options map[string]string
+	// Optional fields, e.g. shared:1
+	Optional []string
+	FSType   string
+	// Source is the device or the remote, e.g. instance:bucket for rclone
+	Source string
+	// SuperOptions of the filesystem, e.g. user_id=1000 for FUSE
+	SuperOptions map[string]string
+}
+
+// unescape decodes the octal escapes of the spaces, tabs, newlines and
+// backslashes of the paths, e.g. \040.
+func unescape(field string) string {
+	if !strings.Contains(field, `\`) {
+		return field
+	}
+
+	var out strings.Builder
+
+	for idx := 0; idx < len(field); idx++ {
+		if field[idx] == '\\' && idx+octalEscapeLen <= len(field) {
+			if char, err := strconv.ParseUint(field[idx+1:idx+octalEscapeLen], 8, 8); err == nil {
+				out.WriteByte(byte(char))
+
+				idx += octalEscapeLen - 1
+
+				continue
+			}
+		}
+
+		out.WriteByte(field[idx])
+	}
+
+	return out.String()
+}
+
+// parseOptions returns the comma separated options, with an empty value for
+// the flags.
+func parseOptions(field string) map[string]string {
+	options := map[string]string{}
+
+	for _, option := range strings.Split(field, ",") {
+		if option == "" {
+			continue
+		}
+
+		parts := strings.SplitN(option, "=", 2) // nolint:gomnd
+		if len(parts) == 1 {
+			options[parts[0]] = ""
+		} else {
+			options[parts[0]] = unescape(parts[1])
+		}
+	}
+
+	return options
+}
+
+// ParseLine parses a line of mountinfo.
+func ParseLine(line string) (Mount, error) {
+	fields := strings.Fields(line)
+	if len(fields) < minFields {
+		return Mount{}, fmt.Errorf("%w: %q", ErrNoValidLine, line)
+	}
+
+	// The optional fields end with a single hyphen
+	separator := -1
+
+	for idx := 6; idx < len(fields); idx++ {
+		if fields[idx] == "-" {
+			separator = idx
+
+			break
+		}
+	}
+
+	if separator == -1 || len(fields) < separator+4 {
+		return Mount{}, fmt.Errorf("%w: %q", ErrNoValidLine, line)
+	}
+
+	id, errID := strconv.Atoi(fields[0])
+	parentID, errParent := strconv.Atoi(fields[1])
+
+	if errID != nil || errParent != nil {
+		return Mount{}, fmt.Errorf("%w: %q", ErrNoValidLine, line)
+	}
+
+	return Mount{
+		ID:           id,
+		ParentID:     parentID,
+		Device:       fields[2],
+		Root:         unescape(fields[3]),
+		MountPoint:   unescape(fields[4]),
+		Options:      parseOptions(fields[5]),
+		Optional:     fields[6:separator],
+		FSType:       fields[separator+1],
+		Source:       unescape(fields[separator+2]),
+		SuperOptions: parseOptions(fields[separator+3]),
+	}, nil
+}
+
+// Parse reads the mounts of a mountinfo file.
+func Parse(reader io.Reader) ([]Mount, error) {
+	mounts := []Mount{}
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		mount, err := ParseLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+
+		mounts = append(mounts, mount)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("mountinfo %w", err)
+	}
+
+	return mounts, nil
+}
+
+// Read returns the mounts of the current process.
+func Read() ([]Mount, error) {
+	file, err := os.Open(Path)
+	if err != nil {
+		return nil, fmt.Errorf("mountinfo %w", err)
+	}
+
+	defer file.Close()
+
+	return Parse(file)
+}
+
+// Resolve returns the absolute path as written in mountinfo, with the
+// symbolic links of the parent folder evaluated, e.g. /var/home/user/bucket
+// for /home/user/bucket if /home links to /var/home. The last element is not
+// evaluated, so a stale mount point is resolved too.
+func Resolve(path string) (string, error) {
+	pathAbs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("resolve %w", err)
+	}
+
+	parent, err := filepath.EvalSymlinks(filepath.Dir(pathAbs))
+	if err != nil {
+		return "", fmt.Errorf("resolve %w", err)
+	}
+
+	return filepath.Join(parent, filepath.Base(pathAbs)), nil
+}
+
+// Find returns the mount at the path, the last one if more filesystems are
+// mounted on the same path, because it hides the others.
+func Find(mounts []Mount, path string) (Mount, bool) {
+	path = filepath.Clean(path)
+
+	for idx := len(mounts) - 1; idx >= 0; idx-- {
+		if mounts[idx].MountPoint == path {
+			return mounts[idx], true
+		}
+	}
+
+	return Mount{}, false
+}
+
+// Check returns the mount at the path, an error if nothing is mounted or if
+// the type or the source of the filesystem are not the expected ones.
+func Check(mounts []Mount, path string, fsType string, source string) (Mount, error) {
+	mount, found := Find(mounts, path)
+	if !found {
+		return mount, fmt.Errorf("%w: %s", ErrNotMounted, path)
+	}
+
+	if mount.FSType != fsType || mount.Source != source {
+		return mount, fmt.Errorf("%w: %s is mounted from %s (%s), expected %s (%s)", ErrOtherFilesystem, path,
+			mount.Source, mount.FSType, source, fsType)
+	}
+
+	return mount, nil
+}
@@ -0,0 +1,175 @@
+package mountinfo
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) ([]Mount, error) {
+	t.Helper()
+
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	return Parse(file)
+}
+
+func TestParseLine(t *testing.T) {
+	mount, err := ParseLine(`412 29 0:52 / /home/user/my\040bucket rw,nosuid,nodev,relatime shared:225 - ` +
+		`fuse.rclone instance:bucket rw,user_id=1000,group_id=1000`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Mount{
+		ID:           412,
+		ParentID:     29,
+		Device:       "0:52",
+		Root:         "/",
+		MountPoint:   "/home/user/my bucket",
+		Options:      map[string]string{"rw": "", "nosuid": "", "nodev": "", "relatime": ""},
+		Optional:     []string{"shared:225"},
+		FSType:       "fuse.rclone",
+		Source:       "instance:bucket",
+		SuperOptions: map[string]string{"rw": "", "user_id": "1000", "group_id": "1000"},
+	}
+
+	if !reflect.DeepEqual(mount, expected) {
+		t.Fatalf("mount %+v != %+v", mount, expected)
+	}
+
+	for _, line := range []string{"", "412 29 0:52 / /mnt rw shared:1 fuse.rclone instance: rw", "a b c d e f - g h i"} {
+		if _, err := ParseLine(line); err == nil {
+			t.Fatalf("line %q is valid", line)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	mounts, err := readFixture(t, "host.mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(mounts) != 8 {
+		t.Fatalf("mounts %d != 8", len(mounts))
+	}
+
+	// A bind mount has the same device of its source and a root below /
+	bind, found := Find(mounts, "/home/user/data")
+	if !found || bind.Root != "/srv/data" || bind.Device != mounts[0].Device {
+		t.Fatalf("bind mount %+v", bind)
+	}
+
+	readOnly, found := Find(mounts, "/home/user/read-only")
+	if _, ro := readOnly.Options["ro"]; !found || !ro || readOnly.SuperOptions["user_id"] != "1000" {
+		t.Fatalf("read-only mount %+v", readOnly)
+	}
+
+	if _, err := readFixture(t, "invalid.mountinfo"); !errors.Is(err, ErrNoValidLine) {
+		t.Fatalf("error %v != %v", err, ErrNoValidLine)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	host, err := readFixture(t, "host.mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stacked, err := readFixture(t, "stacked.mountinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	linked, linkPath := symlinkFixture(t)
+
+	tests := []struct {
+		name     string
+		mounts   []Mount
+		path     string
+		source   string
+		expected error
+		id       int
+	}{
+		{"rclone mount", host, "/home/user/my bucket", "instance:bucket", nil, 412},
+		{"trailing slash", host, "/home/user/read-only/", "instance:bucket/data", nil, 413},
+		{"other remote path", host, "/home/user/read-only", "instance:bucket", ErrOtherFilesystem, 413},
+		{"bind mount", host, "/home/user/data", "instance:bucket", ErrOtherFilesystem, 41},
+		{"not mounted", host, "/home/user", "instance:bucket", ErrNotMounted, 0},
+		{"mounted over", stacked, "/mnt/s3", "instance:bucket", ErrOtherFilesystem, 31},
+		{"behind a symlink", linked, linkPath, "instance:bucket", nil, 412},
+	}
+
+	for _, test := range tests {
+		path := test.path
+		if path == linkPath {
+			// resolved as by the mount point checks, the other fixture paths do not exist
+			if path, err = Resolve(path); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		mount, err := Check(test.mounts, path, "fuse.rclone", test.source)
+		if !errors.Is(err, test.expected) || mount.ID != test.id {
+			t.Fatalf("%s: mount %d, error %v != mount %d, error %v", test.name, mount.ID, err, test.id, test.expected)
+		}
+	}
+}
+
+// symlinkFixture returns the mount of a bucket in a folder reached through a
+// symbolic link, e.g. /home linking to /var/home, and the path with the link.
+func symlinkFixture(t *testing.T) ([]Mount, string) {
+	t.Helper()
+
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "var", "home", "bucket"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(filepath.Join("var", "home"), filepath.Join(tmpDir, "home")); err != nil {
+		t.Fatal(err)
+	}
+
+	mount, err := ParseLine(fmt.Sprintf("412 29 0:52 / %s rw,nosuid,nodev,relatime shared:225 - fuse.rclone "+
+		"instance:bucket rw,user_id=1000,group_id=1000", filepath.Join(tmpDir, "var", "home", "bucket")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []Mount{mount}, filepath.Join(tmpDir, "home", "bucket")
+}
+
+func TestResolve(t *testing.T) {
+	mounts, linkPath := symlinkFixture(t)
+
+	if _, found := Find(mounts, linkPath); found {
+		t.Fatalf("%s found without resolving the symlink", linkPath)
+	}
+
+	resolved, err := Resolve(linkPath)
+	if err != nil || resolved != mounts[0].MountPoint {
+		t.Fatalf("resolved %s, error %v != %s", resolved, err, mounts[0].MountPoint)
+	}
+
+	// A stale mount point cannot be evaluated, so the last element is not
+	if err := os.Remove(mounts[0].MountPoint); err != nil {
+		t.Fatal(err)
+	}
+
+	if resolved, err := Resolve(linkPath); err != nil || resolved != mounts[0].MountPoint {
+		t.Fatalf("stale resolved %s, error %v != %s", resolved, err, mounts[0].MountPoint)
+	}
+}
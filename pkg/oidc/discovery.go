@@ -0,0 +1,186 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	wellKnownPath    = "/.well-known/openid-configuration"
+	discoveryTimeout = 30 * time.Second
+	discoveryTTL     = 1 * time.Hour
+	maxDocumentSize  = 1 << 20
+)
+
+var (
+	ErrDiscovery         = errors.New("OIDC discovery failed")
+	ErrIssuerMismatch    = errors.New("issuer in the discovery document does not match")
+	ErrMissingEndpoint   = errors.New("discovery document without a required endpoint")
+	discoveryCache       = make(map[string]cachedMetadata) // nolint:gochecknoglobals
+	discoveryCacheMutex  sync.Mutex                        // nolint:gochecknoglobals
+	errDiscoveryStatus   = errors.New("unexpected status code")
+	errDiscoveryTooLarge = errors.New("discovery document too large")
+)
+
+// ProviderMetadata is the OpenID Connect discovery document of an issuer.
+// Reference: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+type ProviderMetadata struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint,omitempty"`
+	RevocationEndpoint                string   `json:"revocation_endpoint,omitempty"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint,omitempty"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JwksURI                           string   `json:"jwks_uri,omitempty"`
+	RegistrationEndpoint              string   `json:"registration_endpoint,omitempty"`
+	ScopesSupported                   []string `json:"scopes_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+	ResponseTypesSupported            []string `json:"response_types_supported,omitempty"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+	// Discovered is false when the metadata is a fallback built from the issuer URL.
+	Discovered bool `json:"-"`
+}
+
+type cachedMetadata struct {
+	metadata  *ProviderMetadata
+	fetchedAt time.Time
+}
+
+// SupportsScope checks if the issuer advertises the given scope.
+// An issuer that does not publish the list is assumed to support it.
+func (m *ProviderMetadata) SupportsScope(scope string) bool {
+	return len(m.ScopesSupported) == 0 || contains(m.ScopesSupported, scope)
+}
+
+// SupportsGrant checks if the issuer advertises the given grant type.
+// An issuer that does not publish the list is assumed to support it.
+func (m *ProviderMetadata) SupportsGrant(grant string) bool {
+	return len(m.GrantTypesSupported) == 0 || contains(m.GrantTypesSupported, grant)
+}
+
+// Fallback returns the metadata with the endpoint paths used by INDIGO IAM.
+// It is used when the issuer does not expose a discovery document.
+func Fallback(issuer string) *ProviderMetadata {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	return &ProviderMetadata{ // nolint:exhaustivestruct
+		Issuer:                issuer,
+		AuthorizationEndpoint: issuer + "/authorize",
+		TokenEndpoint:         issuer + "/token",
+		RevocationEndpoint:    issuer + "/revoke",
+		IntrospectionEndpoint: issuer + "/introspect",
+		UserinfoEndpoint:      issuer + "/userinfo",
+		JwksURI:               issuer + "/jwk",
+		RegistrationEndpoint:  issuer + "/register",
+	}
+}
+
+// Discover returns the discovery document of the issuer, using the cached one
+// if it is not expired.
+func Discover(httpClient *http.Client, issuer string) (*ProviderMetadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	return DiscoverWithContext(ctx, httpClient, issuer)
+}
+
+// DiscoverWithContext is like Discover but uses the given context for the request.
+func DiscoverWithContext(ctx context.Context, httpClient *http.Client, issuer string) (*ProviderMetadata, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+
+	discoveryCacheMutex.Lock()
+	cached, inCache := discoveryCache[issuer]
+	discoveryCacheMutex.Unlock()
+
+	if inCache && time.Since(cached.fetchedAt) < discoveryTTL {
+		return cached.metadata, nil
+	}
+
+	metadata, err := fetchMetadata(ctx, httpClient, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryCacheMutex.Lock()
+	discoveryCache[issuer] = cachedMetadata{metadata: metadata, fetchedAt: time.Now()}
+	discoveryCacheMutex.Unlock()
+
+	return metadata, nil
+}
+
+// Forget removes the issuer from the discovery cache.
+func Forget(issuer string) {
+	discoveryCacheMutex.Lock()
+	delete(discoveryCache, strings.TrimSuffix(issuer, "/"))
+	discoveryCacheMutex.Unlock()
+}
+
+func fetchMetadata(ctx context.Context, httpClient *http.Client, issuer string) (*ProviderMetadata, error) {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+wellKnownPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: %v %s", ErrDiscovery, errDiscoveryStatus, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	if len(body) > maxDocumentSize {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, errDiscoveryTooLarge)
+	}
+
+	var metadata ProviderMetadata
+
+	if err := json.Unmarshal(body, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDiscovery, err)
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("%w: expected '%s', got '%s'", ErrIssuerMismatch, issuer, metadata.Issuer)
+	}
+
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" {
+		return nil, fmt.Errorf("%w: authorization and token endpoints are mandatory", ErrMissingEndpoint)
+	}
+
+	metadata.Discovered = true
+
+	return &metadata, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, curValue := range values {
+		if curValue == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,73 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newDiscoveryServer(t *testing.T, issuer func(string) string) (*httptest.Server, *int) {
+	t.Helper()
+
+	hits := 0
+	srv := httptest.NewServer(nil)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != wellKnownPath {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		hits++
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 issuer(srv.URL),
+			"authorization_endpoint": srv.URL + "/protocol/openid-connect/auth",
+			"token_endpoint":         srv.URL + "/protocol/openid-connect/token",
+			"jwks_uri":               srv.URL + "/protocol/openid-connect/certs",
+			"grant_types_supported":  []string{"authorization_code", "refresh_token"},
+		})
+	})
+
+	t.Cleanup(srv.Close)
+
+	return srv, &hits
+}
+
+func TestDiscover(t *testing.T) {
+	srv, hits := newDiscoveryServer(t, func(url string) string { return url })
+
+	metadata, err := Discover(srv.Client(), srv.URL+"/")
+	if err != nil {
+		t.Fatalf("discover error: %s", err)
+	}
+
+	if metadata.TokenEndpoint != srv.URL+"/protocol/openid-connect/token" {
+		t.Fatalf("token endpoint %s", metadata.TokenEndpoint)
+	}
+
+	if !metadata.Discovered || metadata.SupportsGrant("client_credentials") || !metadata.SupportsScope("openid") {
+		t.Fatalf("unexpected metadata %+v", metadata)
+	}
+
+	if _, err := Discover(srv.Client(), srv.URL); err != nil || *hits != 1 {
+		t.Fatalf("discovery document not cached: hits %d, error %v", *hits, err)
+	}
+
+	Forget(srv.URL)
+
+	if _, err := Discover(srv.Client(), srv.URL); err != nil || *hits != 2 {
+		t.Fatalf("discovery document not refreshed: hits %d, error %v", *hits, err)
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	srv, _ := newDiscoveryServer(t, func(string) string { return "https://other.issuer" })
+
+	if _, err := Discover(srv.Client(), srv.URL); !errors.Is(err, ErrIssuerMismatch) {
+		t.Fatalf("expected issuer mismatch, got %v", err)
+	}
+}
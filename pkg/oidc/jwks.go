@@ -0,0 +1,223 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	jwksTTL             = 1 * time.Hour
+	jwksMinRefreshDelay = 1 * time.Minute
+)
+
+var (
+	ErrKeyNotFound       = errors.New("no key found for the token")
+	ErrJWKS              = errors.New("cannot get the issuer keys")
+	errUnsupportedKey    = errors.New("unsupported key")
+	errUnsupportedCurve  = errors.New("unsupported curve")
+	errJWKSStatus        = errors.New("unexpected status code")
+	errJWKSDocumentLarge = errors.New("key set too large")
+)
+
+// JSONWebKey is a public key of a JSON Web Key Set (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// PublicKey decodes the RSA or EC public key.
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %s modulus: %w", k.Kid, err)
+		}
+
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %s exponent: %w", k.Kid, err)
+		}
+
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnsupportedCurve, k.Crv)
+		}
+
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("key %s x: %w", k.Kid, err)
+		}
+
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("key %s y: %w", k.Kid, err)
+		}
+
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedKey, k.Kty)
+	}
+}
+
+// KeySet is the cached JSON Web Key Set of an issuer. The keys are fetched
+// again when they expire or when a token is signed with an unknown key id,
+// so that the rotation of the issuer keys is followed.
+type KeySet struct {
+	URI        string
+	HTTPClient *http.Client
+	mutex      sync.Mutex
+	keys       map[string]crypto.PublicKey
+	fetchedAt  time.Time
+}
+
+// NewKeySet creates the key set of the given jwks_uri.
+func NewKeySet(httpClient *http.Client, uri string) *KeySet {
+	return &KeySet{ // nolint:exhaustivestruct
+		URI:        uri,
+		HTTPClient: httpClient,
+	}
+}
+
+// Key returns the public key with the given key id. An empty key id is
+// accepted only if the issuer publishes a single key.
+func (k *KeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if k.keys == nil || time.Since(k.fetchedAt) > jwksTTL {
+		if err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if key, found := k.lookup(kid); found {
+		return key, nil
+	}
+
+	// Unknown key: the issuer may have rotated its keys
+	if time.Since(k.fetchedAt) > jwksMinRefreshDelay {
+		if err := k.refresh(ctx); err != nil {
+			return nil, err
+		}
+
+		if key, found := k.lookup(kid); found {
+			return key, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: kid '%s'", ErrKeyNotFound, kid)
+}
+
+func (k *KeySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+
+	key, found := k.keys[kid]
+
+	return key, found
+}
+
+func (k *KeySet) refresh(ctx context.Context) error {
+	httpClient := k.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.URI, nil)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKS, err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKS, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %v %s", ErrJWKS, errJWKSStatus, resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKS, err)
+	}
+
+	if len(body) > maxDocumentSize {
+		return fmt.Errorf("%w: %v", ErrJWKS, errJWKSDocumentLarge)
+	}
+
+	var keySet struct {
+		Keys []JSONWebKey `json:"keys"`
+	}
+
+	if err := json.Unmarshal(body, &keySet); err != nil {
+		return fmt.Errorf("%w: %v", ErrJWKS, err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(keySet.Keys))
+
+	for _, curKey := range keySet.Keys {
+		if curKey.Use != "" && curKey.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := curKey.PublicKey()
+		if err != nil {
+			// Skip the keys that cannot be used to verify a signature
+			continue
+		}
+
+		keys[curKey.Kid] = publicKey
+	}
+
+	k.keys = keys
+	k.fetchedAt = time.Now()
+
+	return nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("decode %w", err)
+	}
+
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,322 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	// Register the hash functions used by the supported algorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+const (
+	defaultLeeway = 1 * time.Minute
+	numTokenParts = 3
+)
+
+var (
+	ErrMalformedToken      = errors.New("malformed JWT")
+	ErrUnsupportedAlg      = errors.New("unsupported JWT signing algorithm")
+	ErrInvalidSignature    = errors.New("invalid JWT signature")
+	ErrInvalidIssuer       = errors.New("invalid JWT issuer")
+	ErrInvalidAudience     = errors.New("invalid JWT audience")
+	ErrTokenExpired        = errors.New("JWT expired")
+	ErrTokenNotYetValid    = errors.New("JWT not yet valid")
+	errWrongKeyType        = errors.New("key type does not match the algorithm")
+	errWrongSignatureSize  = errors.New("wrong signature size")
+	errNoVerificationKeys  = errors.New("no key set to verify the signature")
+	supportedAlgorithmHash = map[string]crypto.Hash{ // nolint:gochecknoglobals
+		"RS256": crypto.SHA256,
+		"RS384": crypto.SHA384,
+		"RS512": crypto.SHA512,
+		"PS256": crypto.SHA256,
+		"PS384": crypto.SHA384,
+		"PS512": crypto.SHA512,
+		"ES256": crypto.SHA256,
+		"ES384": crypto.SHA384,
+		"ES512": crypto.SHA512,
+	}
+)
+
+// Audience is the aud claim, that can be a single string or a list.
+type Audience []string
+
+// UnmarshalJSON accepts both the string and the list form.
+func (a *Audience) UnmarshalJSON(data []byte) error {
+	var single string
+
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = Audience{single}
+
+		return nil
+	}
+
+	var list []string
+
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("audience %w", err)
+	}
+
+	*a = list
+
+	return nil
+}
+
+// Contains checks if the audience includes the given value.
+func (a Audience) Contains(value string) bool {
+	return contains(a, value)
+}
+
+// Header is the JOSE header of a JWT.
+type Header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid,omitempty"`
+	Typ string `json:"typ,omitempty"`
+}
+
+// Claims are the JWT claims used by sts-wire.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud,omitempty"`
+	ExpiresAt         int64    `json:"exp,omitempty"`
+	NotBefore         int64    `json:"nbf,omitempty"`
+	IssuedAt          int64    `json:"iat,omitempty"`
+	ClientID          string   `json:"client_id,omitempty"`
+	Scope             string   `json:"scope,omitempty"`
+	Groups            []string `json:"groups,omitempty"`
+	WLCGGroups        []string `json:"wlcg.groups,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	Email             string   `json:"email,omitempty"`
+}
+
+// Scopes returns the scopes of the scope claim.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// AllGroups returns the groups and the wlcg.groups claims.
+func (c *Claims) AllGroups() []string {
+	groups := make([]string, 0, len(c.Groups)+len(c.WLCGGroups))
+	groups = append(groups, c.Groups...)
+
+	for _, group := range c.WLCGGroups {
+		if !contains(groups, group) {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// Expiry returns the expiration time, the zero time if the claim is missing.
+func (c *Claims) Expiry() time.Time {
+	if c.ExpiresAt == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(c.ExpiresAt, 0)
+}
+
+// Token is a parsed, but not yet verified, JWT.
+type Token struct {
+	Header       Header
+	Claims       Claims
+	signingInput string
+	signature    []byte
+}
+
+// ParseUnverified decodes a compact serialized JWT without verifying it.
+func ParseUnverified(rawToken string) (*Token, error) {
+	parts := strings.Split(strings.TrimSpace(rawToken), ".")
+	if len(parts) != numTokenParts {
+		return nil, fmt.Errorf("%w: expected %d parts, got %d", ErrMalformedToken, numTokenParts, len(parts))
+	}
+
+	var token Token
+
+	if err := decodeSegment(parts[0], &token.Header); err != nil {
+		return nil, fmt.Errorf("%w: header %v", ErrMalformedToken, err)
+	}
+
+	if err := decodeSegment(parts[1], &token.Claims); err != nil {
+		return nil, fmt.Errorf("%w: claims %v", ErrMalformedToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature %v", ErrMalformedToken, err)
+	}
+
+	token.signingInput = parts[0] + "." + parts[1]
+	token.signature = signature
+
+	return &token, nil
+}
+
+// Verifier checks the signature and the claims of the tokens of an issuer.
+type Verifier struct {
+	// Issuer expected in the iss claim, skipped if empty
+	Issuer string
+	// Audience accepted values, the aud claim has to contain at least one of them.
+	// The check is skipped if empty
+	Audience []string
+	// KeySet of the issuer
+	KeySet *KeySet
+	// Leeway for the time based claims (default 1 minute)
+	Leeway time.Duration
+	// Now returns the current time (default time.Now)
+	Now func() time.Time
+}
+
+// NewVerifier creates a verifier for the tokens of the discovered issuer.
+func NewVerifier(metadata *ProviderMetadata, keySet *KeySet, audience []string) *Verifier {
+	return &Verifier{ // nolint:exhaustivestruct
+		Issuer:   metadata.Issuer,
+		Audience: audience,
+		KeySet:   keySet,
+	}
+}
+
+// Verify parses the token and checks its signature and claims.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (*Claims, error) {
+	token, err := ParseUnverified(rawToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.KeySet == nil {
+		return nil, errNoVerificationKeys
+	}
+
+	key, err := v.KeySet.Key(ctx, token.Header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := token.verifySignature(key); err != nil {
+		return nil, err
+	}
+
+	if err := v.checkClaims(&token.Claims); err != nil {
+		return nil, err
+	}
+
+	return &token.Claims, nil
+}
+
+func (v *Verifier) checkClaims(claims *Claims) error {
+	if v.Issuer != "" && strings.TrimSuffix(claims.Issuer, "/") != strings.TrimSuffix(v.Issuer, "/") {
+		return fmt.Errorf("%w: expected '%s', got '%s'", ErrInvalidIssuer, v.Issuer, claims.Issuer)
+	}
+
+	if len(v.Audience) > 0 {
+		found := false
+
+		for _, audience := range v.Audience {
+			if claims.Audience.Contains(audience) {
+				found = true
+
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("%w: expected one of %v, got %v", ErrInvalidAudience, v.Audience, []string(claims.Audience))
+		}
+	}
+
+	now := time.Now
+	if v.Now != nil {
+		now = v.Now
+	}
+
+	leeway := v.Leeway
+	if leeway == 0 {
+		leeway = defaultLeeway
+	}
+
+	curTime := now()
+
+	if claims.ExpiresAt != 0 && curTime.After(time.Unix(claims.ExpiresAt, 0).Add(leeway)) {
+		return fmt.Errorf("%w at %s", ErrTokenExpired, time.Unix(claims.ExpiresAt, 0).Format(time.RFC3339))
+	}
+
+	if claims.NotBefore != 0 && curTime.Add(leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return fmt.Errorf("%w before %s", ErrTokenNotYetValid, time.Unix(claims.NotBefore, 0).Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+func (t *Token) verifySignature(key crypto.PublicKey) error {
+	hash, supported := supportedAlgorithmHash[t.Header.Alg]
+	if !supported {
+		return fmt.Errorf("%w: '%s'", ErrUnsupportedAlg, t.Header.Alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(t.signingInput))
+	digest := hasher.Sum(nil)
+
+	switch t.Header.Alg[:2] {
+	case "RS", "PS":
+		rsaKey, isRSA := key.(*rsa.PublicKey)
+		if !isRSA {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, errWrongKeyType)
+		}
+
+		var err error
+
+		if t.Header.Alg[0] == 'R' {
+			err = rsa.VerifyPKCS1v15(rsaKey, hash, digest, t.signature)
+		} else {
+			err = rsa.VerifyPSS(rsaKey, hash, digest, t.signature, nil)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+		}
+	case "ES":
+		ecKey, isEC := key.(*ecdsa.PublicKey)
+		if !isEC {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, errWrongKeyType)
+		}
+
+		keySize := (ecKey.Curve.Params().BitSize + 7) / 8 // nolint:gomnd
+		if len(t.signature) != 2*keySize {
+			return fmt.Errorf("%w: %v", ErrInvalidSignature, errWrongSignatureSize)
+		}
+
+		r := new(big.Int).SetBytes(t.signature[:keySize])
+		s := new(big.Int).SetBytes(t.signature[keySize:])
+
+		if !ecdsa.Verify(ecKey, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return fmt.Errorf("decode %w", err)
+	}
+
+	if err := json.Unmarshal(data, target); err != nil {
+		return fmt.Errorf("unmarshal %w", err)
+	}
+
+	return nil
+}
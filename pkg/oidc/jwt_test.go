@@ -0,0 +1,207 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeIssuer is a local OIDC issuer that signs tokens with a test key pair.
+type fakeIssuer struct {
+	server *httptest.Server
+	mutex  sync.Mutex
+	keys   map[string]*rsa.PrivateKey
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+
+	issuer := &fakeIssuer{keys: make(map[string]*rsa.PrivateKey)} // nolint:exhaustivestruct
+	issuer.addKey(t, "key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(wellKnownPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwk",
+		})
+	})
+	mux.HandleFunc("/jwk", func(w http.ResponseWriter, r *http.Request) {
+		issuer.mutex.Lock()
+		defer issuer.mutex.Unlock()
+
+		keys := make([]JSONWebKey, 0, len(issuer.keys))
+		for kid, key := range issuer.keys {
+			keys = append(keys, JSONWebKey{ // nolint:exhaustivestruct
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: "RS256",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (f *fakeIssuer) addKey(t *testing.T, kid string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %s", err)
+	}
+
+	f.mutex.Lock()
+	f.keys[kid] = key
+	f.mutex.Unlock()
+}
+
+func (f *fakeIssuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(Header{Alg: "RS256", Kid: kid, Typ: "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	f.mutex.Lock()
+	key := f.keys[kid]
+	f.mutex.Unlock()
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign token: %s", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (f *fakeIssuer) claims(audience interface{}, expiresIn time.Duration) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":         f.server.URL,
+		"sub":         "user-1",
+		"aud":         audience,
+		"exp":         time.Now().Add(expiresIn).Unix(),
+		"nbf":         time.Now().Add(-time.Minute).Unix(),
+		"scope":       "openid storage.read:/",
+		"wlcg.groups": []string{"/dodas"},
+	}
+}
+
+func (f *fakeIssuer) verifier(t *testing.T, audience ...string) *Verifier {
+	t.Helper()
+
+	metadata, err := Discover(f.server.Client(), f.server.URL)
+	if err != nil {
+		t.Fatalf("discover: %s", err)
+	}
+
+	return NewVerifier(metadata, NewKeySet(f.server.Client(), metadata.JwksURI), audience)
+}
+
+func TestVerify(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	verifier := issuer.verifier(t, "minio")
+
+	claims, err := verifier.Verify(context.Background(), issuer.sign(t, "key-1", issuer.claims("minio", time.Hour)))
+	if err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+
+	if claims.Subject != "user-1" || claims.AllGroups()[0] != "/dodas" || len(claims.Scopes()) != 2 {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	multiAudience := issuer.claims([]string{"other", "minio"}, time.Hour)
+	if _, err := verifier.Verify(context.Background(), issuer.sign(t, "key-1", multiAudience)); err != nil {
+		t.Fatalf("verify audience list: %s", err)
+	}
+}
+
+func TestVerifyInvalidClaims(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	verifier := issuer.verifier(t, "minio")
+
+	wrongIssuer := issuer.claims("minio", time.Hour)
+	wrongIssuer["iss"] = "https://other.issuer"
+
+	notYetValid := issuer.claims("minio", time.Hour)
+	notYetValid["nbf"] = time.Now().Add(time.Hour).Unix()
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		err    error
+	}{
+		{"audience", issuer.claims("other", time.Hour), ErrInvalidAudience},
+		{"expired", issuer.claims("minio", -time.Hour), ErrTokenExpired},
+		{"issuer", wrongIssuer, ErrInvalidIssuer},
+		{"not before", notYetValid, ErrTokenNotYetValid},
+	}
+
+	for _, test := range tests {
+		if _, err := verifier.Verify(context.Background(), issuer.sign(t, "key-1", test.claims)); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestVerifyInvalidSignature(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	verifier := issuer.verifier(t)
+
+	token := issuer.sign(t, "key-1", issuer.claims("minio", time.Hour))
+	tampered := token[:len(token)-4] + "AAAA"
+
+	if _, err := verifier.Verify(context.Background(), tampered); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected invalid signature, got %v", err)
+	}
+
+	if _, err := verifier.Verify(context.Background(), "not.a-token"); !errors.Is(err, ErrMalformedToken) {
+		t.Fatalf("expected malformed token, got %v", err)
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	verifier := issuer.verifier(t)
+
+	if _, err := verifier.Verify(context.Background(), issuer.sign(t, "key-1", issuer.claims("minio", time.Hour))); err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+
+	issuer.addKey(t, "key-2")
+	rotated := issuer.sign(t, "key-2", issuer.claims("minio", time.Hour))
+
+	// The key set was just fetched: the unknown key is not searched again
+	if _, err := verifier.Verify(context.Background(), rotated); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+
+	verifier.KeySet.fetchedAt = time.Now().Add(-2 * jwksMinRefreshDelay)
+
+	if _, err := verifier.Verify(context.Background(), rotated); err != nil {
+		t.Fatalf("verify with rotated key: %s", err)
+	}
+}
@@ -0,0 +1,67 @@
+package rclone
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// vfsMetaDir is the folder of the VFS cache with the metadata of the files.
+const vfsMetaDir = "vfsMeta"
+
+// cacheItemInfo is the metadata of a file in the VFS cache.
+type cacheItemInfo struct {
+	// Dirty is true when the file has been modified and not uploaded yet
+	Dirty bool `json:"Dirty"`
+}
+
+// DirtyFiles returns the files of the VFS cache in cacheDir that were not
+// uploaded yet, e.g. because rclone was stopped before the upload. rclone
+// uploads them again when it mounts with the same cache folder.
+func DirtyFiles(cacheDir string) ([]string, error) {
+	metaDir := filepath.Join(cacheDir, vfsMetaDir)
+	files := []string{}
+
+	err := filepath.WalkDir(metaDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == metaDir {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if entry.IsDir() {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var info cacheItemInfo
+		if err := json.Unmarshal(data, &info); err != nil {
+			// not a metadata file
+			return nil // nolint:nilerr
+		}
+
+		if info.Dirty {
+			relPath, err := filepath.Rel(metaDir, path)
+			if err != nil {
+				return err
+			}
+
+			files = append(files, relPath)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cache dirty files %w", err)
+	}
+
+	return files, nil
+}
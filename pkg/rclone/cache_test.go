@@ -0,0 +1,41 @@
+package rclone
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestDirtyFiles(t *testing.T) {
+	cacheDir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"instance/bucket/uploaded.txt":    `{"ModTime":"2022-01-01T00:00:00Z","Size":5,"Dirty":false}`,
+		"instance/bucket/dir/pending.txt": `{"ModTime":"2022-01-01T00:00:00Z","Size":5,"Dirty":true}`,
+		"instance/bucket/not-a-meta-file": `garbage`,
+	} {
+		path := filepath.Join(cacheDir, vfsMetaDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := DirtyFiles(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{filepath.Join("instance", "bucket", "dir", "pending.txt")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("dirty files %v != %v", files, expected)
+	}
+
+	if files, err := DirtyFiles(filepath.Join(cacheDir, "missing")); err != nil || len(files) != 0 {
+		t.Fatalf("missing cache has dirty files %v, error: %s", files, err)
+	}
+}
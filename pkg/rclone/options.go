@@ -0,0 +1,221 @@
+package rclone
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+)
+
+var (
+	ErrNoValidMountFlags   = errors.New("no valid rclone mount flags")
+	ErrMissingOptionValue  = errors.New("missing value of rclone mount option")
+	ErrRepeatedMountOption = errors.New("rclone mount option can be used only once")
+)
+
+// MountOption is a rclone mount option with its values. The boolean options
+// have no value, or true or false.
+type MountOption struct {
+	Name   string
+	Values []string
+}
+
+// MountOptions are the rclone mount options, in the order they were set.
+type MountOptions struct {
+	options []MountOption
+}
+
+func (o *MountOptions) index(name string) int {
+	for idx, option := range o.options {
+		if option.Name == name {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// Set replaces the values of an option, or adds it at the end.
+func (o *MountOptions) Set(name string, values ...string) {
+	if idx := o.index(name); idx != -1 {
+		o.options[idx].Values = values
+
+		return
+	}
+
+	o.options = append(o.options, MountOption{Name: name, Values: values})
+}
+
+// Add appends a value to an option, e.g. to a repeated --fuse-flag.
+func (o *MountOptions) Add(name string, value string) {
+	if idx := o.index(name); idx != -1 {
+		o.options[idx].Values = append(o.options[idx].Values, value)
+
+		return
+	}
+
+	o.Set(name, value)
+}
+
+// Get returns the values of an option and if it is set.
+func (o *MountOptions) Get(name string) ([]string, bool) {
+	if idx := o.index(name); idx != -1 {
+		return o.options[idx].Values, true
+	}
+
+	return nil, false
+}
+
+// Delete removes an option.
+func (o *MountOptions) Delete(name string) {
+	if idx := o.index(name); idx != -1 {
+		o.options = append(o.options[:idx], o.options[idx+1:]...)
+	}
+}
+
+// Merge overrides the options key by key: each option of other replaces all
+// the values of the same option.
+func (o *MountOptions) Merge(other MountOptions) {
+	for _, option := range other.options {
+		o.Set(option.Name, option.Values...)
+	}
+}
+
+// Options returns a copy of the options.
+func (o MountOptions) Options() []MountOption {
+	options := make([]MountOption, 0, len(o.options))
+	for _, option := range o.options {
+		options = append(options, MountOption{Name: option.Name, Values: append([]string{}, option.Values...)})
+	}
+
+	return options
+}
+
+// Args returns the command line arguments of the options.
+func (o MountOptions) Args() []string {
+	args := make([]string, 0, len(o.options))
+
+	for _, option := range o.options {
+		hasValue, _, err := validator.RcloneMountOptionKind(option.Name)
+
+		switch {
+		case len(option.Values) == 0:
+			args = append(args, option.Name)
+		case err == nil && !hasValue:
+			// boolean options need the = syntax
+			args = append(args, option.Name+"="+option.Values[0])
+		default:
+			for _, value := range option.Values {
+				args = append(args, option.Name, value)
+			}
+		}
+	}
+
+	return args
+}
+
+func (o MountOptions) String() string {
+	return strings.Join(o.Args(), " ")
+}
+
+// splitFlags splits the flags on white spaces, keeping together the quoted
+// values.
+func splitFlags(flags string) ([]string, error) {
+	var (
+		parts   []string
+		cur     strings.Builder
+		quote   rune
+		hasPart bool
+	)
+
+	for _, char := range flags {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(char)
+		case char == '"' || char == '\'':
+			quote = char
+			hasPart = true
+		case char == ' ' || char == '\t' || char == '\n':
+			if hasPart {
+				parts = append(parts, cur.String())
+				cur.Reset()
+
+				hasPart = false
+			}
+		default:
+			cur.WriteRune(char)
+
+			hasPart = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("%w: unterminated quote %c", ErrNoValidMountFlags, quote)
+	}
+
+	if hasPart {
+		parts = append(parts, cur.String())
+	}
+
+	return parts, nil
+}
+
+// ParseMountOptions parses and validates the rclone mount flags, e.g.
+// "--dir-cache-time 1m --fuse-flag=allow_other --read-only". The value of an
+// option can follow the option or be joined with "=".
+func ParseMountOptions(flags string) (MountOptions, error) { // nolint:cyclop
+	var options MountOptions
+
+	parts, err := splitFlags(flags)
+	if err != nil {
+		return options, err
+	}
+
+	for idx := 0; idx < len(parts); idx++ {
+		name, value, inline := parts[idx], "", false
+		if pos := strings.Index(name, "="); pos != -1 {
+			name, value, inline = name[:pos], name[pos+1:], true
+		}
+
+		if !strings.HasPrefix(name, "--") {
+			return options, fmt.Errorf("%w: expected an option starting with -- instead of '%s'",
+				ErrNoValidMountFlags, parts[idx])
+		}
+
+		hasValue, repeatable, err := validator.RcloneMountOptionKind(name)
+		if err != nil {
+			return options, err
+		}
+
+		if hasValue && !inline {
+			if idx+1 == len(parts) || strings.HasPrefix(parts[idx+1], "--") {
+				return options, fmt.Errorf("%w '%s'", ErrMissingOptionValue, name)
+			}
+
+			idx++
+			value = parts[idx]
+		}
+
+		if _, err := validator.RcloneMountOption(name, value); err != nil {
+			return options, err
+		}
+
+		_, found := options.Get(name)
+
+		switch {
+		case repeatable:
+			options.Add(name, value)
+		case found:
+			return options, fmt.Errorf("%w '%s'", ErrRepeatedMountOption, name)
+		case hasValue || value != "":
+			options.Set(name, value)
+		default:
+			options.Set(name)
+		}
+	}
+
+	return options, nil
+}
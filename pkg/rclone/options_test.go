@@ -0,0 +1,77 @@
+package rclone
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/DODAS-TS/sts-wire/pkg/validator"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	flags := `--dir-cache-time 1m --fuse-flag=allow_other --fuse-flag "default_permissions" --read-only --no-modtime=false`
+
+	options, err := ParseMountOptions(flags)
+	if err != nil {
+		t.Fatalf("flags %s, error: %s", flags, err)
+	}
+
+	expected := []string{
+		"--dir-cache-time", "1m",
+		"--fuse-flag", "allow_other", "--fuse-flag", "default_permissions",
+		"--read-only",
+		"--no-modtime=false",
+	}
+	if args := options.Args(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("args %v != %v", args, expected)
+	}
+
+	if options, err := ParseMountOptions(""); err != nil || len(options.Args()) != 0 {
+		t.Fatalf("empty flags are %v, error: %s", options.Args(), err)
+	}
+}
+
+func TestParseMountOptionsErrors(t *testing.T) {
+	for flags, expected := range map[string]error{
+		"--dir-cache-time":                        ErrMissingOptionValue,
+		"--dir-cache-time --read-only":            ErrMissingOptionValue,
+		"--dir-cache-time=soon":                   validator.ErrNoValidRcloneMountOption,
+		"--vfs-write-back soon":                   validator.ErrNoValidRcloneMountOption,
+		"--vfs-cache-mode full":                   validator.ErrReservedRcloneMountOption,
+		"--cache-dir=/tmp/cache":                  validator.ErrReservedRcloneMountOption,
+		"--read-only=maybe":                       validator.ErrNoValidRcloneMountOption,
+		"--not-an-option":                         validator.ErrUnknownRcloneMountOption,
+		"dir-cache-time 1m":                       ErrNoValidMountFlags,
+		"--volname 'my volume":                    ErrNoValidMountFlags,
+		"--dir-cache-time 1m --dir-cache-time 2m": ErrRepeatedMountOption,
+	} {
+		if _, err := ParseMountOptions(flags); !errors.Is(err, expected) {
+			t.Fatalf("flags %s, error %v != %v", flags, err, expected)
+		}
+	}
+}
+
+func TestMergeMountOptions(t *testing.T) {
+	var defaults MountOptions
+
+	defaults.Set("--cache-dir", "/tmp/cache")
+	defaults.Set("--vfs-write-wait", "2s")
+	defaults.Set("--read-only")
+
+	user, err := ParseMountOptions("--vfs-write-wait 5s --read-only=false --option allow_other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defaults.Merge(user)
+
+	expected := []string{
+		"--cache-dir", "/tmp/cache",
+		"--vfs-write-wait", "5s",
+		"--read-only=false",
+		"--option", "allow_other",
+	}
+	if args := defaults.Args(); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("args %v != %v", args, expected)
+	}
+}
@@ -0,0 +1,293 @@
+package rclone
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	rcHost          = "127.0.0.1"
+	rcUser          = "sts-wire"
+	rcPasswordBytes = 24
+	rcTimeout       = 30 * time.Second
+)
+
+var ErrRC = errors.New("rclone rc error")
+
+// RC is the remote control API of a rclone process, served on localhost with
+// random credentials.
+type RC struct {
+	URL      string `json:"url"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// NewRC returns the remote control endpoint of a new rclone process, on a
+// free port of localhost.
+func NewRC() (RC, error) {
+	listener, err := net.Listen("tcp", rcHost+":0")
+	if err != nil {
+		return RC{}, fmt.Errorf("rclone rc port %w", err)
+	}
+
+	addr := listener.Addr().String()
+	listener.Close()
+
+	password := make([]byte, rcPasswordBytes)
+	if _, err := rand.Read(password); err != nil {
+		return RC{}, fmt.Errorf("rclone rc password %w", err)
+	}
+
+	return RC{
+		URL:      "http://" + addr,
+		User:     rcUser,
+		Password: hex.EncodeToString(password),
+	}, nil
+}
+
+// Args returns the rclone flags that serve the remote control API.
+func (rc RC) Args() []string {
+	return []string{"--rc", "--rc-addr", rc.URL[len("http://"):]}
+}
+
+// Env returns the credentials of the remote control API as rclone environment
+// variables, not visible in the process list like the flags.
+func (rc RC) Env() []string {
+	return []string{"RCLONE_RC_USER=" + rc.User, "RCLONE_RC_PASS=" + rc.Password}
+}
+
+// rcError is the answer of rclone when a call fails.
+type rcError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// Call executes a remote control command, e.g. vfs/stats, with the params
+// and decodes the answer in result, if not nil.
+func (rc RC) Call(ctx context.Context, command string, params interface{}, result interface{}) error {
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("rclone rc %s %w", command, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, rcTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rc.URL+"/"+command, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("rclone rc %s %w", command, err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth(rc.User, rc.Password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("rclone rc %s %w", command, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResponse rcError
+		if errDecode := json.NewDecoder(resp.Body).Decode(&errResponse); errDecode != nil || errResponse.Error == "" {
+			return fmt.Errorf("%w: %s: %s", ErrRC, command, resp.Status)
+		}
+
+		return fmt.Errorf("%w: %s: %s", ErrRC, command, errResponse.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("rclone rc %s response %w", command, err)
+	}
+
+	return nil
+}
+
+// VFSDiskCache is the state of the VFS cache on disk.
+type VFSDiskCache struct {
+	Path              string `json:"path"`
+	BytesUsed         int64  `json:"bytesUsed"`
+	Files             int    `json:"files"`
+	ErroredFiles      int    `json:"erroredFiles"`
+	OutOfSpace        bool   `json:"outOfSpace"`
+	UploadsInProgress int    `json:"uploadsInProgress"`
+	UploadsQueued     int    `json:"uploadsQueued"`
+}
+
+// VFSStats are the statistics of the VFS of the mount, the disk cache is nil
+// when the cache mode is off.
+type VFSStats struct {
+	Fs        string        `json:"fs"`
+	InUse     int           `json:"inUse"`
+	DiskCache *VFSDiskCache `json:"diskCache,omitempty"`
+}
+
+// PendingUploads returns the files in the cache to upload or being uploaded.
+func (s VFSStats) PendingUploads() int {
+	if s.DiskCache == nil {
+		return 0
+	}
+
+	return s.DiskCache.UploadsInProgress + s.DiskCache.UploadsQueued
+}
+
+// VFSStats returns the statistics of the VFS.
+func (rc RC) VFSStats(ctx context.Context) (VFSStats, error) {
+	var stats VFSStats
+
+	err := rc.Call(ctx, "vfs/stats", nil, &stats)
+
+	return stats, err
+}
+
+// BwLimit is the bandwidth limit of rclone.
+type BwLimit struct {
+	// Rate is the limit, e.g. 10M, 10M:1M or off
+	Rate             string `json:"rate"`
+	BytesPerSecond   int64  `json:"bytesPerSecond"`
+	BytesPerSecondTx int64  `json:"bytesPerSecondTx"`
+	BytesPerSecondRx int64  `json:"bytesPerSecondRx"`
+}
+
+// BwLimit sets the bandwidth limit, a rate or a timetable, and returns the
+// current one. An empty rate only returns the current limit.
+func (rc RC) BwLimit(ctx context.Context, rate string) (BwLimit, error) {
+	var (
+		limit  BwLimit
+		params interface{}
+	)
+
+	if rate != "" {
+		params = map[string]interface{}{"rate": rate}
+	}
+
+	err := rc.Call(ctx, "core/bwlimit", params, &limit)
+
+	return limit, err
+}
+
+// Transfer is a file being transferred.
+type Transfer struct {
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"`
+	Bytes      int64   `json:"bytes"`
+	Percentage int     `json:"percentage"`
+	Speed      float64 `json:"speed"`
+}
+
+// CoreStats are the statistics of the transfers of rclone.
+type CoreStats struct {
+	Bytes          int64      `json:"bytes"`
+	TotalBytes     int64      `json:"totalBytes"`
+	Speed          float64    `json:"speed"`
+	Transfers      int        `json:"transfers"`
+	TotalTransfers int        `json:"totalTransfers"`
+	Checks         int        `json:"checks"`
+	Deletes        int        `json:"deletes"`
+	Renames        int        `json:"renames"`
+	Errors         int        `json:"errors"`
+	LastError      string     `json:"lastError"`
+	FatalError     bool       `json:"fatalError"`
+	RetryError     bool       `json:"retryError"`
+	ElapsedTime    float64    `json:"elapsedTime"`
+	Transferring   []Transfer `json:"transferring"`
+}
+
+// Stats returns the statistics of the transfers.
+func (rc RC) Stats(ctx context.Context) (CoreStats, error) {
+	var stats CoreStats
+
+	err := rc.Call(ctx, "core/stats", nil, &stats)
+
+	return stats, err
+}
+
+// numberedParams returns the params of the commands that accept more values
+// of a key, as key, key2, key3...
+func numberedParams(params map[string]interface{}, key string, values []string) {
+	for idx, value := range values {
+		name := key
+		if idx > 0 {
+			name = fmt.Sprintf("%s%d", key, idx+1)
+		}
+
+		params[name] = value
+	}
+}
+
+// VFSRefresh reads again the directories of the remote, all of them if none
+// is given, and returns the result of each directory.
+func (rc RC) VFSRefresh(ctx context.Context, recursive bool, dirs ...string) (map[string]string, error) {
+	var response struct {
+		Result map[string]string `json:"result"`
+	}
+
+	params := map[string]interface{}{}
+	if recursive {
+		params["recursive"] = "true"
+	}
+
+	numberedParams(params, "dir", dirs)
+
+	err := rc.Call(ctx, "vfs/refresh", params, &response)
+
+	return response.Result, err
+}
+
+// VFSForget forgets the cached metadata of the files and of the directories,
+// all the cache if none is given, and returns the forgotten paths.
+func (rc RC) VFSForget(ctx context.Context, files []string, dirs []string) ([]string, error) {
+	var response struct {
+		Forgotten []string `json:"forgotten"`
+	}
+
+	params := map[string]interface{}{}
+	numberedParams(params, "file", files)
+	numberedParams(params, "dir", dirs)
+
+	err := rc.Call(ctx, "vfs/forget", params, &response)
+
+	return response.Forgotten, err
+}
+
+// PID returns the process id of rclone.
+func (rc RC) PID(ctx context.Context) (int, error) {
+	var response struct {
+		PID int `json:"pid"`
+	}
+
+	err := rc.Call(ctx, "core/pid", nil, &response)
+
+	return response.PID, err
+}
+
+// ConfigUpdate changes the parameters of a remote of the rclone config, e.g.
+// the new credentials.
+func (rc RC) ConfigUpdate(ctx context.Context, name string, parameters map[string]string) error {
+	return rc.Call(ctx, "config/update", map[string]interface{}{
+		"name":       name,
+		"parameters": parameters,
+	}, nil)
+}
+
+// Quit asks rclone to exit with the exit code.
+func (rc RC) Quit(ctx context.Context, exitCode int) error {
+	return rc.Call(ctx, "core/quit", map[string]interface{}{"exitCode": exitCode}, nil)
+}
@@ -0,0 +1,150 @@
+package rclone
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeRC answers like the remote control API of rclone.
+func fakeRC(t *testing.T, rc *RC, answers map[string]string) *httptest.Server {
+	t.Helper()
+
+	expectedUser, expectedPassword := rc.User, rc.Password
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, password, ok := r.BasicAuth(); !ok || user != expectedUser || password != expectedPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+
+			return
+		}
+
+		answer, found := answers[strings.TrimPrefix(r.URL.Path, "/")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"couldn't find method","status":404}`))
+
+			return
+		}
+
+		_, _ = w.Write([]byte(answer))
+	}))
+
+	rc.URL = server.URL
+
+	return server
+}
+
+func TestRCVFSStats(t *testing.T) {
+	rc, err := NewRC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := fakeRC(t, &rc, map[string]string{
+		"vfs/stats": `{"fs":"instance:/bucket","inUse":1,"diskCache":{"bytesUsed":1024,"files":3,` +
+			`"uploadsInProgress":1,"uploadsQueued":2}}`,
+	})
+	defer server.Close()
+
+	stats, err := rc.VFSStats(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stats.PendingUploads() != 3 || stats.DiskCache.Files != 3 {
+		t.Fatalf("stats %+v, pending uploads %d != 3", stats.DiskCache, stats.PendingUploads())
+	}
+
+	if err := rc.Call(context.Background(), "vfs/unknown", nil, nil); !errors.Is(err, ErrRC) ||
+		!strings.Contains(err.Error(), "couldn't find method") {
+		t.Fatalf("error %v != %v", err, ErrRC)
+	}
+
+	rc.Password = "wrong"
+	if _, err := rc.VFSStats(context.Background()); !errors.Is(err, ErrRC) {
+		t.Fatalf("error %v != %v", err, ErrRC)
+	}
+}
+
+func TestRCBwLimit(t *testing.T) {
+	rc, err := NewRC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := fakeRC(t, &rc, map[string]string{
+		"core/bwlimit": `{"bytesPerSecond":1048576,"bytesPerSecondTx":1048576,"bytesPerSecondRx":-1,"rate":"1M:off"}`,
+	})
+	defer server.Close()
+
+	limit, err := rc.BwLimit(context.Background(), "1M:off")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limit.Rate != "1M:off" || limit.BytesPerSecondTx != 1048576 {
+		t.Fatalf("bandwidth limit %+v != 1M:off", limit)
+	}
+}
+
+func TestRCCommands(t *testing.T) {
+	rc, err := NewRC()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := fakeRC(t, &rc, map[string]string{
+		"core/stats": `{"bytes":2048,"totalBytes":4096,"transfers":1,"errors":1,"lastError":"access denied",` +
+			`"transferring":[{"name":"data.csv","size":4096,"bytes":2048,"percentage":50}]}`,
+		"vfs/refresh":   `{"result":{"data":"OK","logs":"file does not exist"}}`,
+		"vfs/forget":    `{"forgotten":["data/file.csv"]}`,
+		"config/update": `{}`,
+		"core/pid":      `{"pid":1234}`,
+		"core/quit":     `{}`,
+	})
+	defer server.Close()
+
+	ctx := context.Background()
+
+	stats, err := rc.Stats(ctx)
+	if err != nil || stats.Errors != 1 || len(stats.Transferring) != 1 || stats.Transferring[0].Percentage != 50 {
+		t.Fatalf("stats %+v, error: %s", stats, err)
+	}
+
+	result, err := rc.VFSRefresh(ctx, true, "data", "logs")
+	if err != nil || result["data"] != "OK" {
+		t.Fatalf("refresh %v, error: %s", result, err)
+	}
+
+	forgotten, err := rc.VFSForget(ctx, []string{"data/file.csv"}, nil)
+	if err != nil || len(forgotten) != 1 {
+		t.Fatalf("forgotten %v, error: %s", forgotten, err)
+	}
+
+	if pid, err := rc.PID(ctx); err != nil || pid != 1234 {
+		t.Fatalf("pid %d, error: %s", pid, err)
+	}
+
+	if err := rc.ConfigUpdate(ctx, "instance", map[string]string{"access_key_id": "key"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := rc.Quit(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNumberedParams(t *testing.T) {
+	params := map[string]interface{}{}
+	numberedParams(params, "dir", []string{"a", "b", "c"})
+
+	expected := map[string]interface{}{"dir": "a", "dir2": "b", "dir3": "c"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Fatalf("params %v != %v", params, expected)
+	}
+}
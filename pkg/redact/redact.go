@@ -0,0 +1,205 @@
+// Package redact masks the secrets written in the logs and in the reports:
+// the JWTs, the OAuth secrets, the passwords and the S3 keys.
+package redact
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// Mask replaces the redacted values.
+const Mask = "[REDACTED]"
+
+var (
+	// sensitiveSuffixes of the normalized field names whose value is masked.
+	sensitiveSuffixes = []string{ // nolint:gochecknoglobals
+		"secret", "password", "passwd", "token", "secretkey", "privatekey", "authorization",
+	}
+	// jsonString matches a string field of a JSON object.
+	jsonString = regexp.MustCompile(`"([^"\\]*)":"((?:[^"\\]|\\.)*)"`)
+	// jwt matches the compact serialization of a JWT.
+	jwt = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	// keyValue matches the secrets in query strings, form bodies and configuration files.
+	keyValue = regexp.MustCompile(`(?i)\b(client_secret|refresh_token|access_token|id_token|subject_token|` +
+		`password|passwd|secret_access_key|session_token|secret_key|webidentitytoken|ldappassword|` +
+		`secretaccesskey|sessiontoken)\b(["']?\s*[:=]\s*["']?)([^"'&\s,<\\]+)`)
+	// bareKeyValue matches the generic names only in query strings and form bodies.
+	bareKeyValue = regexp.MustCompile(`(?i)\b(token|secret)(=)([^"'&\s,<\\]+)`)
+	// xmlTag matches the credentials of the STS responses.
+	xmlTag = regexp.MustCompile(`<(SecretAccessKey|SessionToken)>[^<]*</(SecretAccessKey|SessionToken)>`)
+	// authHeader matches the value of an Authorization header.
+	authHeader = regexp.MustCompile(`(?i)\b(Basic|Bearer)\s+[A-Za-z0-9._~+/=-]{8,}`)
+	// disabled is set to write the secrets in clear.
+	disabled int32 // nolint:gochecknoglobals
+)
+
+// Disable turns off the redaction. Use it only for a deep debugging session.
+func Disable() {
+	atomic.StoreInt32(&disabled, 1)
+}
+
+// Enabled reports if the secrets are redacted.
+func Enabled() bool {
+	return atomic.LoadInt32(&disabled) == 0
+}
+
+// SensitiveKey reports if a field with the given name holds a secret.
+func SensitiveKey(key string) bool {
+	normalized := strings.ToLower(key)
+
+	for _, sep := range []string{"_", "-", ".", " "} {
+		normalized = strings.ReplaceAll(normalized, sep, "")
+	}
+
+	for _, suffix := range sensitiveSuffixes {
+		if strings.HasSuffix(normalized, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String masks the secrets found in a text, e.g. a log line or an error.
+func String(text string) string {
+	if !Enabled() {
+		return text
+	}
+
+	text = jsonString.ReplaceAllStringFunc(text, func(field string) string {
+		match := jsonString.FindStringSubmatch(field)
+		if match[2] == "" {
+			return field
+		}
+
+		if SensitiveKey(match[1]) {
+			return `"` + match[1] + `":"` + Mask + `"`
+		}
+
+		return `"` + match[1] + `":"` + patterns(match[2]) + `"`
+	})
+
+	return patterns(text)
+}
+
+// Value masks the secrets of a configuration value, e.g. the viper settings,
+// by field name and by pattern.
+func Value(key string, value interface{}) interface{} {
+	if !Enabled() {
+		return value
+	}
+
+	switch curValue := value.(type) {
+	case string:
+		if curValue != "" && SensitiveKey(key) {
+			return Mask
+		}
+
+		return patterns(curValue)
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(curValue))
+		for curKey, item := range curValue {
+			redacted[curKey] = Value(curKey, item)
+		}
+
+		return redacted
+	case map[interface{}]interface{}:
+		redacted := make(map[interface{}]interface{}, len(curValue))
+		for curKey, item := range curValue {
+			keyString, _ := curKey.(string)
+			redacted[curKey] = Value(keyString, item)
+		}
+
+		return redacted
+	case map[string]string:
+		redacted := make(map[string]string, len(curValue))
+		for curKey, item := range curValue {
+			redacted[curKey], _ = Value(curKey, item).(string)
+		}
+
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(curValue))
+		for idx, item := range curValue {
+			redacted[idx] = Value(key, item)
+		}
+
+		return redacted
+	case []string:
+		redacted := make([]string, len(curValue))
+		for idx, item := range curValue {
+			redacted[idx], _ = Value(key, item).(string)
+		}
+
+		return redacted
+	default:
+		return value
+	}
+}
+
+func patterns(text string) string {
+	text = jwt.ReplaceAllString(text, Mask)
+	text = keyValue.ReplaceAllString(text, "${1}${2}"+Mask)
+	text = bareKeyValue.ReplaceAllString(text, "${1}${2}"+Mask)
+	text = xmlTag.ReplaceAllString(text, "<${1}>"+Mask+"</${2}>")
+
+	return authHeader.ReplaceAllString(text, "${1} "+Mask)
+}
+
+// Writer masks the secrets of the events written by a zerolog logger.
+type Writer struct {
+	out io.Writer
+}
+
+// NewWriter returns a writer that redacts the events before writing them to out.
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+// Write the redacted event. The length of the original event is returned, as
+// expected by the logger.
+func (w *Writer) Write(p []byte) (int, error) {
+	if !Enabled() {
+		return w.out.Write(p)
+	}
+
+	if _, err := w.out.Write(w.redact(p)); err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	return len(p), nil
+}
+
+// WriteLevel writes the redacted event with its level.
+func (w *Writer) WriteLevel(level zerolog.Level, p []byte) (int, error) {
+	levelWriter, ok := w.out.(zerolog.LevelWriter)
+	if !ok {
+		return w.Write(p)
+	}
+
+	if !Enabled() {
+		return levelWriter.WriteLevel(level, p)
+	}
+
+	if _, err := levelWriter.WriteLevel(level, w.redact(p)); err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	return len(p), nil
+}
+
+func (w *Writer) redact(p []byte) []byte {
+	trailingNewline := bytes.HasSuffix(p, []byte("\n"))
+
+	redacted := String(strings.TrimSuffix(string(p), "\n"))
+	if trailingNewline {
+		redacted += "\n"
+	}
+
+	return []byte(redacted)
+}
@@ -1,16 +1,31 @@
 package template
 
-// ClientTemplate used to compose client oauth2 request
+import (
+	"encoding/json"
+	"text/template"
+)
+
+// Funcs are the functions of the templates: json encodes a value, quoting and
+// escaping the strings, for the JSON documents.
+var Funcs = template.FuncMap{"json": jsonValue} // nolint:gochecknoglobals
+
+func jsonValue(value interface{}) (string, error) {
+	encoded, err := json.Marshal(value)
+
+	return string(encoded), err
+}
+
+// ClientTemplate used to compose client oauth2 request, parsed with Funcs
 const ClientTemplate = `{
 	"redirect_uris": [
-	  "http://{{ .Host }}:{{ .Port }}/oauth2/callback"
+	  {{ json (printf "http://%s:%d/oauth2/callback" .Host .Port) }}
 	],
-	"client_name": "{{ .ClientName }}",
+	"client_name": {{ json .ClientName }},
 	"contacts": [
 	  "client@iam.test"
 	],
 	"token_endpoint_auth_method": "client_secret_basic",
-	"scope": "address phone openid email profile offline_access",
+	"scope": {{ json .Scope }},
 	"grant_types": [
 	  "refresh_token",
 	  "authorization_code"
@@ -24,11 +39,11 @@ const ClientTemplate = `{
 const RCloneTemplate = `
 [{{ .Instance }}]
 type = s3
-provider = INFN Cloud
+provider = {{ .Provider }}
 oidc_agent = false
 account =
 env_auth = false
-access_key_id =
-secret_access_key =
-session_token =
+access_key_id ={{ if .AccessKeyID }} {{ .AccessKeyID }}{{ end }}
+secret_access_key ={{ if .SecretAccessKey }} {{ .SecretAccessKey }}{{ end }}
+session_token ={{ if .SessionToken }} {{ .SessionToken }}{{ end }}
 endpoint = {{ .Address }}`
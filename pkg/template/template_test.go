@@ -0,0 +1,42 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"text/template"
+)
+
+func TestClientTemplate(t *testing.T) {
+	tmpl, err := template.New("client").Funcs(Funcs).Parse(ClientTemplate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := struct {
+		Host       string
+		Port       int
+		ClientName string
+		Scope      string
+	}{"localhost", 3128, `sts-wire "test" \ client`, `openid offline_access storage.read:/a"b\c`}
+
+	var request bytes.Buffer
+	if err := tmpl.Execute(&request, config); err != nil {
+		t.Fatal(err)
+	}
+
+	var client struct {
+		RedirectURIs []string `json:"redirect_uris"`
+		ClientName   string   `json:"client_name"`
+		Scope        string   `json:"scope"`
+	}
+
+	if err := json.Unmarshal(request.Bytes(), &client); err != nil {
+		t.Fatalf("invalid JSON %s: %v", request.String(), err)
+	}
+
+	if len(client.RedirectURIs) != 1 || client.RedirectURIs[0] != "http://localhost:3128/oauth2/callback" ||
+		client.ClientName != config.ClientName || client.Scope != config.Scope {
+		t.Fatalf("client %+v", client)
+	}
+}
@@ -0,0 +1,37 @@
+package validator
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Errors collects the validation errors of a configuration so that all of
+// them can be reported at once.
+type Errors []error
+
+// Add adds an error, if not nil, prefixed by the name of the field.
+func (e *Errors) Add(field string, err error) {
+	if err != nil {
+		*e = append(*e, fmt.Errorf("%s: %w", field, err))
+	}
+}
+
+// Err returns the collected errors or nil if there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Error returns one error per line.
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "\n")
+}
@@ -1,27 +1,35 @@
 package validator
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"syscall"
 )
 
 const (
 	minRefreshTokenDuration = 15
+	maxSessionPolicySize    = 2048
+	maxPort                 = 65535
 )
 
 var (
-	ErrNoValidSize                  = errors.New("no valid uid")
-	validSize                       = regexp.MustCompile(`^((\d*\.?\d+)[KMBkmb]?){1}$`)
+	ErrNoValidSize                  = errors.New("no valid size")
+	validSize                       = regexp.MustCompile(`^(off|\d*\.?\d+[BbKkMmGgTtPp]?)$`)
 	ErrNoValidUID                   = errors.New("no valid uid")
-	validUID                        = regexp.MustCompile(`^([\d]+){4}$`)
+	validUID                        = regexp.MustCompile(`^\d+$`)
 	ErrNoValidPermission            = errors.New("no valid permission")
-	validPermission                 = regexp.MustCompile(`^([\d]+){4}$`)
+	validPermission                 = regexp.MustCompile(`^0?[0-7]{3}$`)
 	ErrNoValidDuration              = errors.New("no valid duration")
-	validDuration                   = regexp.MustCompile(`^(\d+h){0,1}(\d+(m|ms){0,1}){0,1}(\d+s){0,1}$`)
+	validDuration                   = regexp.MustCompile(`^(off|0|(\d*\.?\d+(ns|us|µs|ms|s|m|h|d|w|M|y))+)$`)
+	validFuseOption                 = regexp.MustCompile(`^[\w\-=.,:/]+$`)
+	ErrNoValidBwLimit               = errors.New("no valid bandwidth limit")
+	validBwRate                     = regexp.MustCompile(`^(off|\d*\.?\d+[BbKkMmGgTtPp]?)(:(off|\d*\.?\d+[BbKkMmGgTtPp]?))?$`)
+	validBwSlot                     = regexp.MustCompile(`^((Mon|Tue|Wed|Thu|Fri|Sat|Sun)-)?([01]?\d|2[0-3]):[0-5]\d,(.+)$`)
 	ErrNoValidFile                  = errors.New("no valid file")
 	validFile                       = regexp.MustCompile(`^([a-zA-Z_\:\\\-\s0-9\.\/]+)+$`)
 	ErrNoValidLogFile               = errors.New("no valid log file")
@@ -37,131 +45,239 @@ var (
 	validInstanceName               = regexp.MustCompile(`^[\w\-_]+$`)
 	ErrNoValidRefreshTokenRenewTime = errors.New("no valid refresh token time duration: min 15min")
 	ErrNoValidRcloneMountOption     = errors.New("mount option not valid")
-	rcloneMountOptions              map[string]interface{} // nolint:gochecknoglobals
+	ErrUnknownRcloneMountOption     = errors.New("unknown rclone mount option")
+	ErrReservedRcloneMountOption    = errors.New("rclone mount option set by sts-wire")
+	ErrNoValidSTSAction             = errors.New("no valid STS action")
+	ErrNoValidRoleArn               = errors.New("no valid role ARN")
+	validRoleArn                    = regexp.MustCompile(`^arn:[\w\-]+:iam:[\w\-]*:[\w\-]*:role/[\w+=,.@\-/]+$`)
+	ErrNoValidRoleSessionName       = errors.New("no valid role session name")
+	validRoleSessionName            = regexp.MustCompile(`^[\w+=,.@\-]{2,64}$`)
+	ErrNoValidSessionPolicy         = errors.New("no valid session policy")
+	ErrNoValidLocalCache            = errors.New("no valid local cache mode, use off, minimal, writes or full")
+	ErrNoValidPort                  = errors.New("no valid port")
+	ErrNoValidLogLevel              = errors.New("no valid log level, use trace, debug, info, warn or error")
+	rcloneMountOptions              map[string]rcloneMountOption // nolint:gochecknoglobals
+	validSTSActions                 = []string{                  // nolint:gochecknoglobals
+		"AssumeRoleWithWebIdentity",
+		"AssumeRoleWithClientGrants",
+		"AssumeRoleWithLDAPIdentity",
+		"AssumeRole",
+	}
 )
 
+// rcloneMountOption describes a rclone mount option: check is nil for the
+// boolean options, a regexp, a function or the list of the valid values
+// otherwise. The reserved options are set only by sts-wire, from the
+// configuration key in reserved.
+type rcloneMountOption struct {
+	check    interface{}
+	expected string
+	repeat   bool
+	reserved string
+}
+
 func init() {
-	rcloneMountOptions = make(map[string]interface{})
-	// Allow mounting over a non-empty directory. Not supported on Windows.
-	rcloneMountOptions["--allow-non-empty"] = nil
-	// Allow access to other users. Not supported on Windows.
-	rcloneMountOptions["--allow-other"] = nil
-	// Allow access to root user. Not supported on Windows.
-	rcloneMountOptions["--allow-root"] = nil
-	// Use asynchronous reads. Not supported on Windows. (default true)
-	rcloneMountOptions["--async-read"] = nil
-	// Time for which file/directory attributes are cached. (default 1s)
-	rcloneMountOptions["--attr-timeout"] = validDuration
-	// Run mount as a daemon (background mode). Not supported on Windows.
-	rcloneMountOptions["--daemon"] = nil
-	// Time limit for rclone to respond to kernel. Not supported on Windows.
-	rcloneMountOptions["--daemon-timeout"] = validDuration
-	// Debug the FUSE internals - needs -v.
-	rcloneMountOptions["--debug-fuse"] = nil
-	// Makes kernel enforce access control based on the file mode. Not supported on Windows.
-	rcloneMountOptions["--default-permissions"] = nil
-	// Time to cache directory entries for. (default 5m0s)
-	rcloneMountOptions["--dir-cache-time"] = validDuration
-	// Directory permissions (default 0777)
-	rcloneMountOptions["--dir-perms"] = validPermission
-	// File permissions (default 0666)
-	rcloneMountOptions["--file-perms"] = validPermission
-	// Flags or arguments to be passed direct to libfuse/WinFsp. Repeat if required.
-	// rcloneMountOptions["--fuse-flag stringArray"] = []string{}
-	// Override the gid field set by the filesystem. Not supported on Windows. (default 1000)
-	rcloneMountOptions["--gid"] = validUID
-	// The number of bytes that can be prefetched for sequential reads. Not supported on Windows. (default 128k)
-	rcloneMountOptions["--max-read-ahead"] = validSize
-	// Mount as remote network drive, instead of fixed disk drive. Supported on Windows only
-	rcloneMountOptions["--network-mode"] = nil
-	// Don't compare checksums on up/download.
-	rcloneMountOptions["--no-checksum"] = nil
-	// Don't read/write the modification time (can speed things up).
-	rcloneMountOptions["--no-modtime"] = nil
-	// Don't allow seeking in files.
-	rcloneMountOptions["--no-seek"] = nil
-	// Ignore Apple Double (._) and .DS_Store files. Supported on OSX only. (default true)
-	rcloneMountOptions["--noappledouble"] = nil
-	// Ignore all "com.apple.*" extended attributes. Supported on OSX only.
-	rcloneMountOptions["--noapplexattr"] = nil
-	// Option for libfuse/WinFsp. Repeat if required.
-	rcloneMountOptions["--option stringArray"] = nil
-	// Time to wait between polling for changes. Must be smaller than dir-cache-time. Only on supported remotes. Set to 0 to disable. (default 1m0s)
-	rcloneMountOptions["--poll-interval"] = validDuration
-	// Mount read-only.
-	rcloneMountOptions["--read-only"] = nil
-	// Override the uid field set by the filesystem. Not supported on Windows. (default 1000)
-	rcloneMountOptions["--uid"] = validUID
-	// Override the permission bits set by the filesystem. Not supported on Windows.
-	rcloneMountOptions["--umask int"] = nil
-	// Max age of objects in the cache. (default 1h0m0s)
-	rcloneMountOptions["--vfs-cache-max-age"] = validDuration
-	// Max total size of objects in the cache. (default off)
-	rcloneMountOptions["--vfs-cache-max-size"] = validSize
-	// Cache mode off|minimal|writes|full (default off)
-	rcloneMountOptions["--vfs-cache-mode"] = []string{"off", "minimal", "writes", "full"}
-	// Interval to poll the cache for stale objects. (default 1m0s)
-	rcloneMountOptions["--vfs-cache-poll-interval"] = validDuration
-	// If a file name not found, find a case insensitive match.
-	rcloneMountOptions["--vfs-case-insensitive"] = nil
-	// Extra read ahead over --buffer-size when using cache-mode full.
-	rcloneMountOptions["--vfs-read-ahead"] = validSize
-	// Read the source objects in chunks. (default 128M)
-	rcloneMountOptions["--vfs-read-chunk-size"] = validSize
-	// If greater than --vfs-read-chunk-size, double the chunk size after each chunk read, until the limit is reached. 'off' is unlimited. (default off)
-	rcloneMountOptions["--vfs-read-chunk-size-limit"] = validSize
-	// Time to wait for in-sequence read before seeking. (default 20ms)
-	rcloneMountOptions["--vfs-read-wait"] = validDuration
-	// Use the rclone size algorithm for Used size.
-	//rcloneMountOptions["--vfs-used-is-size rclone size"] = []string{}
-	// Time to writeback files after last use when using cache. (default 5s)
-	rcloneMountOptions["--vfs-write-back"] = validDuration
-	// Time to wait for in-sequence write before giving error. (default 1s)
-	rcloneMountOptions["--vfs-write-wait"] = validDuration
-	// Set the volume name. Supported on Windows and OSX only.
-	rcloneMountOptions["--volname"] = validPath
-	// Makes kernel buffer writes before sending them to rclone.
-	// Without this, writethrough caching is used. Not supported on Windows.
-	rcloneMountOptions["--write-back-cache"] = nil
+	optFlag := rcloneMountOption{check: nil, expected: "true or false", repeat: false, reserved: ""}
+	optDuration := rcloneMountOption{check: validDuration, expected: "a duration, e.g. 10s or 1h30m", repeat: false, reserved: ""}
+	optSize := rcloneMountOption{check: validSize, expected: "a size, e.g. 128M or off", repeat: false, reserved: ""}
+	optNumber := rcloneMountOption{check: validUID, expected: "a number", repeat: false, reserved: ""}
+	optUID := rcloneMountOption{check: validUID, expected: "a numeric id", repeat: false, reserved: ""}
+	optPermission := rcloneMountOption{check: validPermission, expected: "octal permissions, e.g. 0755", repeat: false, reserved: ""}
+	optPath := rcloneMountOption{check: validPath, expected: "a path", repeat: false, reserved: ""}
+	optBwLimit := rcloneMountOption{
+		check: func(limit string) bool {
+			_, err := BwLimit(limit)
+
+			return err == nil
+		},
+		expected: "a rate or a timetable, e.g. 10M, 10M:1M or \"08:00,1M 19:00,off\"", repeat: false, reserved: "",
+	}
+	optFuseOption := rcloneMountOption{check: validFuseOption, expected: "a FUSE option, e.g. allow_other", repeat: true, reserved: ""}
+
+	rcloneMountOptions = map[string]rcloneMountOption{
+		// Local directory of the VFS cache.
+		"--cache-dir": rcloneMountOption{check: validPath, expected: "a path", repeat: false, reserved: "localCacheDir"},
+		// In memory buffer size when reading files for each open file.
+		"--buffer-size": optSize,
+		// Bandwidth limit in KiB/s, or use suffix B|K|M|G|T|P or a full timetable.
+		"--bwlimit": optBwLimit,
+		// Bandwidth limit per file in KiB/s, or use suffix B|K|M|G|T|P or a full timetable.
+		"--bwlimit-file": optBwLimit,
+		// Number of file transfers to run in parallel. (default 4)
+		"--transfers": optNumber,
+		// Allow mounting over a non-empty directory. Not supported on Windows.
+		"--allow-non-empty": optFlag,
+		// Allow access to other users. Not supported on Windows.
+		"--allow-other": optFlag,
+		// Allow access to root user. Not supported on Windows.
+		"--allow-root": optFlag,
+		// Use asynchronous reads. Not supported on Windows. (default true)
+		"--async-read": optFlag,
+		// Time for which file/directory attributes are cached. (default 1s)
+		"--attr-timeout": optDuration,
+		// Run mount as a daemon (background mode). Not supported on Windows.
+		"--daemon": optFlag,
+		// Time limit for rclone to respond to kernel. Not supported on Windows.
+		"--daemon-timeout": optDuration,
+		// Debug the FUSE internals - needs -v.
+		"--debug-fuse": optFlag,
+		// Makes kernel enforce access control based on the file mode. Not supported on Windows.
+		"--default-permissions": optFlag,
+		// Time to cache directory entries for. (default 5m0s)
+		"--dir-cache-time": optDuration,
+		// Directory permissions (default 0777)
+		"--dir-perms": optPermission,
+		// File permissions (default 0666)
+		"--file-perms": optPermission,
+		// Flags or arguments to be passed direct to libfuse/WinFsp. Repeat if required.
+		"--fuse-flag": optFuseOption,
+		// Override the gid field set by the filesystem. Not supported on Windows. (default 1000)
+		"--gid": optUID,
+		// The number of bytes that can be prefetched for sequential reads. Not supported on Windows. (default 128k)
+		"--max-read-ahead": optSize,
+		// Mount as remote network drive, instead of fixed disk drive. Supported on Windows only
+		"--network-mode": optFlag,
+		// Don't compare checksums on up/download.
+		"--no-checksum": optFlag,
+		// Don't read/write the modification time (can speed things up).
+		"--no-modtime": optFlag,
+		// Don't allow seeking in files.
+		"--no-seek": optFlag,
+		// Ignore Apple Double (._) and .DS_Store files. Supported on OSX only. (default true)
+		"--noappledouble": optFlag,
+		// Ignore all "com.apple.*" extended attributes. Supported on OSX only.
+		"--noapplexattr": optFlag,
+		// Option for libfuse/WinFsp. Repeat if required.
+		"--option": optFuseOption,
+		// Time to wait between polling for changes. Must be smaller than dir-cache-time. Only on supported remotes. Set to 0 to disable. (default 1m0s)
+		"--poll-interval": optDuration,
+		// Mount read-only.
+		"--read-only": optFlag,
+		// Override the uid field set by the filesystem. Not supported on Windows. (default 1000)
+		"--uid": optUID,
+		// Override the permission bits set by the filesystem. Not supported on Windows.
+		"--umask": optPermission,
+		// Max age of objects in the cache. (default 1h0m0s)
+		"--vfs-cache-max-age": optDuration,
+		// Max total size of objects in the cache. (default off)
+		"--vfs-cache-max-size": optSize,
+		// Cache mode off|minimal|writes|full (default off)
+		"--vfs-cache-mode": rcloneMountOption{
+			check: []string{"off", "minimal", "writes", "full"}, expected: "off, minimal, writes or full", repeat: false,
+			reserved: "localCache",
+		},
+		// Interval to poll the cache for stale objects. (default 1m0s)
+		"--vfs-cache-poll-interval": optDuration,
+		// If a file name not found, find a case insensitive match.
+		"--vfs-case-insensitive": optFlag,
+		// Extra read ahead over --buffer-size when using cache-mode full.
+		"--vfs-read-ahead": optSize,
+		// Read the source objects in chunks. (default 128M)
+		"--vfs-read-chunk-size": optSize,
+		// If greater than --vfs-read-chunk-size, double the chunk size after each chunk read, until the limit is reached. 'off' is unlimited. (default off)
+		"--vfs-read-chunk-size-limit": optSize,
+		// Time to wait for in-sequence read before seeking. (default 20ms)
+		"--vfs-read-wait": optDuration,
+		// Use the rclone size algorithm for Used size.
+		"--vfs-used-is-size": optFlag,
+		// Time to writeback files after last use when using cache. (default 5s)
+		"--vfs-write-back": optDuration,
+		// Time to wait for in-sequence write before giving error. (default 1s)
+		"--vfs-write-wait": optDuration,
+		// Set the volume name. Supported on Windows and OSX only.
+		"--volname": optPath,
+		// Makes kernel buffer writes before sending them to rclone.
+		// Without this, writethrough caching is used. Not supported on Windows.
+		"--write-back-cache": optFlag,
+	}
+}
+
+func lookupRcloneMountOption(name string) (rcloneMountOption, error) {
+	option, found := rcloneMountOptions[name]
+	if !found {
+		return option, fmt.Errorf("%w '%s'", ErrUnknownRcloneMountOption, name)
+	}
+
+	if option.reserved != "" {
+		return option, fmt.Errorf("%w '%s', use %s instead", ErrReservedRcloneMountOption, name, option.reserved)
+	}
+
+	return option, nil
 }
 
-// RcloneMountFlags checks if the flags are valid rclone mount flags
-func RcloneMountFlags(flagList string) (bool, error) {
-	parts := strings.Split(flagList, " ")
-
-	for idx := 0; idx < len(parts); idx++ {
-		curPart := parts[idx]
-		if value, inMap := rcloneMountOptions[curPart]; inMap {
-			switch checkMethod := value.(type) {
-			case nil:
-				continue
-			case *regexp.Regexp:
-				nextPart := parts[idx+1]
-				if !checkMethod.MatchString(nextPart) {
-					return false, fmt.Errorf("%w '%s %s'", ErrNoValidRcloneMountOption, curPart, nextPart)
-				}
-
-				idx++
-			case []string:
-				nextPart := parts[idx+1]
-				found := false
-
-				for _, val := range value.([]string) {
-					if nextPart == val {
-						found = true
-					}
-				}
-
-				if !found {
-					return false, fmt.Errorf("%w '%s %s'", ErrNoValidRcloneMountOption, curPart, nextPart)
-				}
-				idx++
+// RcloneMountOptionKind returns if a rclone mount option needs a value and if
+// it can be repeated.
+func RcloneMountOptionKind(name string) (hasValue bool, repeatable bool, err error) {
+	option, err := lookupRcloneMountOption(name)
+	if err != nil {
+		return false, false, err
+	}
+
+	return option.check != nil, option.repeat, nil
+}
+
+// RcloneMountOption checks a rclone mount option with its value. The value of
+// the boolean options is optional and can be true or false.
+func RcloneMountOption(name string, value string) (bool, error) {
+	option, err := lookupRcloneMountOption(name)
+	if err != nil {
+		return false, err
+	}
+
+	valid := false
+
+	switch check := option.check.(type) {
+	case nil:
+		valid = value == "" || value == "true" || value == "false"
+	case *regexp.Regexp:
+		valid = check.MatchString(value)
+	case func(string) bool:
+		valid = check(value)
+	case []string:
+		for _, validValue := range check {
+			if value == validValue {
+				valid = true
 			}
-		} else {
-			return false, fmt.Errorf("%w '%s'", ErrNoValidRcloneMountOption, curPart)
 		}
 	}
 
+	if !valid {
+		return false, fmt.Errorf("%w '%s %s': expected %s", ErrNoValidRcloneMountOption, name, value, option.expected)
+	}
+
+	return true, nil
+}
+
+// Size checks if the size is valid for rclone, e.g. 10G, 128M or off.
+func Size(size string) (bool, error) {
+	if !validSize.MatchString(size) {
+		return false, fmt.Errorf("%w '%s', use e.g. 10G, 128M or off", ErrNoValidSize, size)
+	}
+
+	return true, nil
+}
+
+// BwLimit checks if the bandwidth limit is valid for rclone: a rate, e.g. 10M,
+// an upload:download rate, e.g. 10M:1M, or a timetable of [Day-]HH:MM,rate
+// slots, e.g. "Mon-08:00,1M 19:00,off".
+func BwLimit(limit string) (bool, error) {
+	slots := strings.Fields(limit)
+
+	if len(slots) == 1 && validBwRate.MatchString(slots[0]) {
+		return true, nil
+	}
+
+	for _, slot := range slots {
+		parts := validBwSlot.FindStringSubmatch(slot)
+		if parts == nil || !validBwRate.MatchString(parts[len(parts)-1]) {
+			return false, fmt.Errorf("%w '%s', use e.g. 10M, 10M:1M or \"08:00,1M 19:00,off\"", ErrNoValidBwLimit, slot)
+		}
+	}
+
+	if len(slots) == 0 {
+		return false, fmt.Errorf("%w: empty", ErrNoValidBwLimit)
+	}
+
 	return true, nil
 }
 
@@ -174,6 +290,87 @@ func RefreshTokenRenew(minutes int) (bool, error) {
 	return true, nil
 }
 
+// STSAction checks if the STS action is supported.
+func STSAction(action string) (bool, error) {
+	for _, validAction := range validSTSActions {
+		if action == validAction {
+			return true, nil
+		}
+	}
+
+	return false, fmt.Errorf("%w: '%s'", ErrNoValidSTSAction, action)
+}
+
+// RoleArn checks if the role ARN is valid, e.g. arn:minio:iam:::role/readonly.
+func RoleArn(arn string) (bool, error) {
+	if !validRoleArn.MatchString(arn) {
+		return false, fmt.Errorf("%w: '%s'", ErrNoValidRoleArn, arn)
+	}
+
+	return true, nil
+}
+
+// RoleSessionName checks if the role session name is valid.
+func RoleSessionName(name string) (bool, error) {
+	if !validRoleSessionName.MatchString(name) {
+		return false, fmt.Errorf("%w: '%s'", ErrNoValidRoleSessionName, name)
+	}
+
+	return true, nil
+}
+
+// SessionPolicy checks if the inline session policy is a JSON policy document
+// that the STS accepts.
+func SessionPolicy(policy string) (bool, error) {
+	if len(policy) > maxSessionPolicySize {
+		return false, fmt.Errorf("%w: size %d exceeds %d bytes", ErrNoValidSessionPolicy, len(policy), maxSessionPolicySize)
+	}
+
+	var document struct {
+		Version   string
+		Statement []json.RawMessage
+	}
+
+	if err := json.Unmarshal([]byte(policy), &document); err != nil {
+		return false, fmt.Errorf("%w: %v", ErrNoValidSessionPolicy, err)
+	}
+
+	if len(document.Statement) == 0 {
+		return false, fmt.Errorf("%w: no statement", ErrNoValidSessionPolicy)
+	}
+
+	return true, nil
+}
+
+// LocalCache checks if the local cache mode is one of the rclone VFS cache modes.
+func LocalCache(mode string) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "off", "minimal", "writes", "full":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: '%s'", ErrNoValidLocalCache, mode)
+	}
+}
+
+// LogLevel checks if the log level is supported.
+func LogLevel(level string) (bool, error) {
+	switch strings.ToLower(level) {
+	case "trace", "debug", "info", "warn", "error":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%w: '%s'", ErrNoValidLogLevel, level)
+	}
+}
+
+// Port checks if the port is valid. Zero means a random port.
+func Port(port int) (bool, error) {
+	if port < 0 || port > maxPort {
+		return false, fmt.Errorf("%w: %d", ErrNoValidPort, port)
+	}
+
+	return true, nil
+}
+
 // LogFile checks if the path indicated for the log file is valid.
 func LogFile(logFilePath string) (bool, error) {
 	if !validLogFile.MatchString(logFilePath) {
@@ -240,8 +437,9 @@ func LocalPath(localMountPoint string) (bool, error) {
 		return false, fmt.Errorf("no valid path: %w, abs error", errAbs)
 	}
 
+	// A stale mount point, e.g. after a crash, is checked and unmounted at startup
 	_, errStat := os.Stat(absPath)
-	if errStat != nil && !os.IsNotExist(errStat) {
+	if errStat != nil && !os.IsNotExist(errStat) && !errors.Is(errStat, syscall.ENOTCONN) {
 		return false, fmt.Errorf("no valid path: %w, something wront", errStat)
 	}
 
@@ -1,6 +1,8 @@
 package validator
 
 import (
+	"errors"
+	"strings"
 	"testing"
 )
 
@@ -28,3 +30,147 @@ func TestRootValidLocalDir(t *testing.T) {
 		t.Fatalf(`localDir %s is %t != %t, error: %s`, localDir, valid, false, err)
 	}
 }
+
+func TestValidRoleArn(t *testing.T) {
+	for _, arn := range []string{"arn:minio:iam:::role/readonly", "arn:aws:iam::123456789012:role/s3-share"} {
+		if valid, err := RoleArn(arn); !valid || err != nil {
+			t.Fatalf(`role ARN %s is %t != %t, error: %s`, arn, valid, true, err)
+		}
+	}
+
+	arn := "role/readonly"
+	if valid, err := RoleArn(arn); valid || err == nil {
+		t.Fatalf(`role ARN %s is %t != %t`, arn, valid, false)
+	}
+}
+
+func TestValidRoleSessionName(t *testing.T) {
+	name := "sts-wire@my-laptop"
+	if valid, err := RoleSessionName(name); !valid || err != nil {
+		t.Fatalf(`role session name %s is %t != %t, error: %s`, name, valid, true, err)
+	}
+
+	name = "sts wire; rm -f /"
+	if valid, err := RoleSessionName(name); valid || err == nil {
+		t.Fatalf(`role session name %s is %t != %t`, name, valid, false)
+	}
+}
+
+func TestValidSessionPolicy(t *testing.T) {
+	policy := `{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Effect": "Allow",
+      "Action": ["s3:GetObject", "s3:ListBucket"],
+      "Resource": ["arn:aws:s3:::shared", "arn:aws:s3:::shared/folder/*"]
+    }
+  ]
+}`
+	if valid, err := SessionPolicy(policy); !valid || err != nil {
+		t.Fatalf(`policy is %t != %t, error: %s`, valid, true, err)
+	}
+
+	for _, policy := range []string{`{"Version": "2012-10-17"}`, `not a policy`, strings.Repeat(" ", 4096)} {
+		if valid, err := SessionPolicy(policy); valid || !errors.Is(err, ErrNoValidSessionPolicy) {
+			t.Fatalf(`policy %q is %t != %t, error: %s`, policy, valid, false, err)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+
+	_, err := InstanceName("my-instance")
+	errs.Add("instanceName", err)
+
+	if errs.Err() != nil {
+		t.Fatalf("unexpected errors: %s", errs)
+	}
+
+	_, err = InstanceName("my instance")
+	errs.Add("instanceName", err)
+	_, err = LocalCache("sometimes")
+	errs.Add("localCache", err)
+
+	if len(errs) != 2 || errs.Err() == nil {
+		t.Fatalf("expected 2 errors, got %d: %s", len(errs), errs)
+	}
+
+	if !errors.Is(errs[0], ErrNoValidInstanceName) || !strings.HasPrefix(errs[0].Error(), "instanceName: ") {
+		t.Fatalf("unexpected error: %s", errs[0])
+	}
+
+	if lines := strings.Split(errs.Error(), "\n"); len(lines) != 2 {
+		t.Fatalf("expected one error per line: %q", errs.Error())
+	}
+}
+
+func TestValidLocalCache(t *testing.T) {
+	for _, mode := range []string{"off", "MINIMAL", "writes", "full"} {
+		if valid, err := LocalCache(mode); !valid || err != nil {
+			t.Fatalf(`local cache %s is %t != %t, error: %s`, mode, valid, true, err)
+		}
+	}
+
+	if valid, err := LocalCache("always"); valid || !errors.Is(err, ErrNoValidLocalCache) {
+		t.Fatalf(`local cache always is %t != %t, error: %s`, valid, false, err)
+	}
+}
+
+func TestValidRcloneMountOption(t *testing.T) {
+	for name, value := range map[string]string{
+		"--dir-cache-time":     "1h30m",
+		"--vfs-cache-max-size": "10G",
+		"--uid":                "0",
+		"--umask":              "022",
+		"--vfs-write-back":     "10s",
+		"--read-only":          "",
+		"--allow-other":        "true",
+		"--fuse-flag":          "allow_other",
+	} {
+		if valid, err := RcloneMountOption(name, value); !valid || err != nil {
+			t.Fatalf(`option %s %s is %t != %t, error: %s`, name, value, valid, true, err)
+		}
+	}
+
+	if _, err := RcloneMountOption("--dir-perms", "rwx"); !errors.Is(err, ErrNoValidRcloneMountOption) {
+		t.Fatalf("error %v != %v", err, ErrNoValidRcloneMountOption)
+	}
+
+	if _, err := RcloneMountOption("--unknown", ""); !errors.Is(err, ErrUnknownRcloneMountOption) {
+		t.Fatalf("error %v != %v", err, ErrUnknownRcloneMountOption)
+	}
+
+	if _, err := RcloneMountOption("--vfs-cache-mode", "full"); !errors.Is(err, ErrReservedRcloneMountOption) {
+		t.Fatalf("error %v != %v", err, ErrReservedRcloneMountOption)
+	}
+}
+
+func TestValidSize(t *testing.T) {
+	for _, size := range []string{"10G", "128M", "1.5g", "1024", "off"} {
+		if valid, err := Size(size); !valid || err != nil {
+			t.Fatalf(`size %s is %t != %t, error: %s`, size, valid, true, err)
+		}
+	}
+
+	for _, size := range []string{"", "10GB", "-1M", "lots"} {
+		if valid, err := Size(size); valid || !errors.Is(err, ErrNoValidSize) {
+			t.Fatalf(`size %s is %t != %t, error: %s`, size, valid, false, err)
+		}
+	}
+}
+
+func TestValidBwLimit(t *testing.T) {
+	for _, limit := range []string{"10M", "off", "10M:1M", "off:512k", "08:00,1M 19:00,off", "Mon-08:00,512k Sat-00:00,10M:off"} {
+		if valid, err := BwLimit(limit); !valid || err != nil {
+			t.Fatalf(`bandwidth limit %s is %t != %t, error: %s`, limit, valid, true, err)
+		}
+	}
+
+	for _, limit := range []string{"", "fast", "10M 1M", "25:00,1M", "08:00,fast", "Someday-08:00,1M"} {
+		if valid, err := BwLimit(limit); valid || !errors.Is(err, ErrNoValidBwLimit) {
+			t.Fatalf(`bandwidth limit %s is %t != %t, error: %s`, limit, valid, false, err)
+		}
+	}
+}